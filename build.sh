@@ -141,7 +141,7 @@ EOM
 cd src/\$repo2
 go test -v
 
-CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o /target/linux.bin -ldflags "-X main.Version=v${app_version}-${logspout_version}" github.com/gliderlabs/logspout
+CGO_ENABLED=0 GOOS=linux go build -a -installsuffix cgo -o /target/linux.bin -ldflags "-X main.Version=v${app_version}-${logspout_version} -X \$repo2.Version=v${app_version}" github.com/gliderlabs/logspout
 EOF
 
 chmod a+x "$golangbuilder"
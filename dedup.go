@@ -0,0 +1,147 @@
+package redis
+
+import (
+	"hash/fnv"
+	"regexp"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	DEFAULT_DEDUP_MAX_ENTRIES = 10000
+	DEDUP_FLUSH_INTERVAL      = 100 * time.Millisecond
+)
+
+var (
+	dedupUUID   = regexp.MustCompile(`[0-9A-Fa-f]{8}-(?:[0-9A-Fa-f]{4}-){3}[0-9A-Fa-f]{12}`)
+	dedupDigits = regexp.MustCompile(`[0-9]+`)
+)
+
+// RepeatFields describe a log line that was seen more than once.
+type RepeatFields struct {
+	Count int    `json:"repeat_count"`
+	First string `json:"first_timestamp"`
+	Last  string `json:"last_timestamp"`
+}
+
+type dedupKey struct {
+	container string
+	source    string
+	hash      uint64
+}
+
+type dedupEntry struct {
+	key      dedupKey
+	m        *router.Message
+	received time.Time
+	first    time.Time
+	last     time.Time
+	count    int
+}
+
+// Repeat returns the repeat fields of the entry, or nil if the log line was
+// seen once.
+func (e *dedupEntry) Repeat() *RepeatFields {
+	if e.count < 2 {
+		return nil
+	}
+	return &RepeatFields{
+		Count: e.count,
+		First: e.first.UTC().Format(time.RFC3339Nano),
+		Last:  e.last.UTC().Format(time.RFC3339Nano),
+	}
+}
+
+// deduplicator collapses identical log lines of a container and source, seen
+// within window, into a single log line. Log lines are held for window, so
+// every log line is delayed by window. At most max_entries log lines are held;
+// when full, the oldest is released early.
+type deduplicator struct {
+	window      time.Duration
+	normalize   bool
+	max_entries int
+	entries     map[dedupKey]*dedupEntry
+	// entries in the order they were first seen, which is also the order in
+	// which their window ends
+	order []*dedupEntry
+	// number of entries, read by the metrics handler
+	held int64
+}
+
+func newDeduplicator(window time.Duration, normalize bool, max_entries int) *deduplicator {
+	if max_entries < 1 {
+		max_entries = DEFAULT_DEDUP_MAX_ENTRIES
+	}
+	return &deduplicator{
+		window:      window,
+		normalize:   normalize,
+		max_entries: max_entries,
+		entries:     make(map[dedupKey]*dedupEntry),
+	}
+}
+
+// Add holds m, or counts it as a repeat of a held log line. It returns the log
+// lines released to stay within max_entries.
+func (d *deduplicator) Add(m *router.Message, now time.Time) []*dedupEntry {
+	key := dedupKey{container: m.Container.ID, source: m.Source, hash: d.hash(m.Data)}
+	if entry, ok := d.entries[key]; ok {
+		entry.count++
+		entry.last = m.Time
+		return nil
+	}
+
+	var released []*dedupEntry
+	for len(d.entries) >= d.max_entries {
+		released = append(released, d.pop())
+	}
+	entry := &dedupEntry{key: key, m: m, received: now, first: m.Time, last: m.Time, count: 1}
+	d.entries[key] = entry
+	d.order = append(d.order, entry)
+	atomic.AddInt64(&d.held, 1)
+	return released
+}
+
+// Flush returns the log lines whose window has ended.
+func (d *deduplicator) Flush(now time.Time) []*dedupEntry {
+	var released []*dedupEntry
+	for len(d.order) > 0 && !now.Before(d.order[0].received.Add(d.window)) {
+		released = append(released, d.pop())
+	}
+	return released
+}
+
+// FlushAll returns all held log lines.
+func (d *deduplicator) FlushAll() []*dedupEntry {
+	var released []*dedupEntry
+	for len(d.order) > 0 {
+		released = append(released, d.pop())
+	}
+	return released
+}
+
+func (d *deduplicator) pop() *dedupEntry {
+	entry := d.order[0]
+	d.order[0] = nil
+	d.order = d.order[1:]
+	delete(d.entries, entry.key)
+	atomic.AddInt64(&d.held, -1)
+	return entry
+}
+
+// Held returns the number of log lines held. Unlike the other methods, it can
+// be called from any goroutine.
+func (d *deduplicator) Held() int {
+	return int(atomic.LoadInt64(&d.held))
+}
+
+func (d *deduplicator) hash(data string) uint64 {
+	if d.normalize {
+		data = dedupUUID.ReplaceAllString(data, "<uuid>")
+		data = dedupDigits.ReplaceAllString(data, "0")
+	}
+	h := fnv.New64a()
+	h.Write([]byte(data))
+	return h.Sum64()
+}
@@ -0,0 +1,118 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+// at sets the time a log line was received.
+func at(m *router.Message, t time.Time) *router.Message {
+	m.Time = t
+	return m
+}
+
+func entryData(entries []*dedupEntry) []string {
+	var data []string
+	for _, entry := range entries {
+		data = append(data, entry.m.Data)
+	}
+	return data
+}
+
+func TestDeduplicator(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	d := newDeduplicator(time.Second, false, 0)
+
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "retrying", nil), now), now))
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "other", nil), now), now))
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "retrying", nil), now.Add(100*time.Millisecond)), now.Add(100*time.Millisecond)))
+	assert.Nil(d.Add(at(testMessage("a", "stderr", "retrying", nil), now), now))
+	assert.Nil(d.Add(at(testMessage("b", "stdout", "retrying", nil), now), now))
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "retrying", nil), now.Add(900*time.Millisecond)), now.Add(900*time.Millisecond)))
+
+	assert.Len(d.Flush(now.Add(999*time.Millisecond)), 0)
+
+	released := d.Flush(now.Add(time.Second))
+	assert.Equal([]string{"retrying", "other", "retrying", "retrying"}, entryData(released))
+	assert.Equal(&RepeatFields{
+		Count: 3,
+		First: "2016-01-26T13:01:50Z",
+		Last:  "2016-01-26T13:01:50.9Z",
+	}, released[0].Repeat())
+	assert.Nil(released[1].Repeat())
+	assert.Equal("stderr", released[2].m.Source)
+	assert.Equal("b", released[3].m.Container.ID)
+	assert.Len(d.entries, 0)
+
+	// a new window starts after a flush
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "retrying", nil), now.Add(time.Second)), now.Add(time.Second)))
+	assert.Len(d.FlushAll(), 1)
+}
+
+func TestDeduplicatorNormalize(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	d := newDeduplicator(time.Second, true, 0)
+
+	d.Add(at(testMessage("a", "stdout", "attempt 1 for 3f2504e0-4f89-11d3-9a0c-0305e82c3301", nil), now), now)
+	d.Add(at(testMessage("a", "stdout", "attempt 22 for 7c9e6679-7425-40de-944b-e07fc1f90ae7", nil), now), now)
+	d.Add(at(testMessage("a", "stdout", "attempt x for 7c9e6679-7425-40de-944b-e07fc1f90ae7", nil), now), now)
+
+	released := d.FlushAll()
+	assert.Len(released, 2)
+	// the first log line is shipped
+	assert.Equal("attempt 1 for 3f2504e0-4f89-11d3-9a0c-0305e82c3301", released[0].m.Data)
+	assert.Equal(2, released[0].Repeat().Count)
+
+	d = newDeduplicator(time.Second, false, 0)
+	d.Add(at(testMessage("a", "stdout", "attempt 1", nil), now), now)
+	d.Add(at(testMessage("a", "stdout", "attempt 2", nil), now), now)
+	assert.Len(d.FlushAll(), 2)
+}
+
+func TestDeduplicatorMaxEntries(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	d := newDeduplicator(time.Minute, false, 2)
+
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "1", nil), now), now))
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "2", nil), now), now))
+	assert.Nil(d.Add(at(testMessage("a", "stdout", "1", nil), now), now))
+	released := d.Add(at(testMessage("a", "stdout", "3", nil), now), now)
+	assert.Equal([]string{"1"}, entryData(released))
+	assert.Equal(2, released[0].Repeat().Count)
+	assert.Len(d.entries, 2)
+	assert.Len(d.order, 2)
+	assert.Equal(2, d.Held())
+	d.FlushAll()
+	assert.Equal(0, d.Held())
+}
+
+func TestCreateLogstashMessageWithRepeat(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := at(testMessage("a", "stdout", "retrying", nil), time.Unix(1453813310, 0))
+	repeat := &RepeatFields{Count: 5, First: "2016-01-26T13:01:50Z", Last: "2016-01-26T13:01:55Z"}
+
+	msg, _ := createLogstashMessage(m, &messageOptions{repeat: repeat})
+	jq := makeQuery(msg)
+	assert.Equal(5, getInt(jq, "repeat_count"))
+	assert.Equal("2016-01-26T13:01:50Z", getString(jq, "first_timestamp"))
+	assert.Equal("2016-01-26T13:01:55Z", getString(jq, "last_timestamp"))
+
+	msg, _ = createLogstashMessage(m, &messageOptions{repeat: repeat, use_v0: true})
+	assert.Equal(5, getInt(makeQuery(msg), "@fields", "repeat_count"))
+
+	msg, _ = createLogstashMessage(m, &messageOptions{})
+	_, err := makeQuery(msg).Int("repeat_count")
+	assert.NotNil(err)
+
+}
@@ -0,0 +1,35 @@
+package redis
+
+import (
+	"strings"
+)
+
+// envDenyList holds patterns for environment variables that are never shipped,
+// regardless of the include_env setting. Matching is case insensitive.
+var envDenyList = newGlobList("*PASSWORD*,*PASSWD*,*SECRET*,*TOKEN*,*CREDENTIAL*,*PRIVATE*,*API_KEY*,*ACCESS_KEY*")
+
+// filterEnv returns the variables from a container environment (in KEY=value
+// notation) matching include, minus those on the deny list.
+func filterEnv(env []string, include globList) map[string]string {
+	if len(include) == 0 {
+		return nil
+	}
+
+	var filtered map[string]string
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			continue
+		}
+		name, value := parts[0], parts[1]
+		if !include.Match(name) || envDenyList.Match(strings.ToUpper(name)) {
+			continue
+		}
+		if filtered == nil {
+			filtered = make(map[string]string)
+		}
+		filtered[name] = value
+	}
+
+	return filtered
+}
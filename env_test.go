@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterEnv(t *testing.T) {
+	assert := assert.New(t)
+
+	env := []string{
+		"APP_VERSION=1.2.3",
+		"GIT_SHA=abc123",
+		"TENANT=acme",
+		"PATH=/usr/bin",
+		"OPTS=a=b",
+		"EMPTY=",
+		"NOVALUE",
+	}
+
+	assert.Nil(filterEnv(env, nil))
+	assert.Equal(map[string]string{"APP_VERSION": "1.2.3", "GIT_SHA": "abc123", "TENANT": "acme"},
+		filterEnv(env, newGlobList("APP_*,GIT_SHA,TENANT")))
+	assert.Equal(map[string]string{"OPTS": "a=b", "EMPTY": ""}, filterEnv(env, newGlobList("OPTS,EMPTY,NOVALUE")))
+	assert.Nil(filterEnv(env, newGlobList("NOPE")))
+}
+
+func TestFilterEnvDenyList(t *testing.T) {
+	assert := assert.New(t)
+
+	env := []string{
+		"DB_PASSWORD=hunter2",
+		"aws_secret_access_key=xyz",
+		"GITHUB_TOKEN=ghp",
+		"STRIPE_API_KEY=sk",
+		"APP_NAME=web",
+	}
+
+	assert.Equal(map[string]string{"APP_NAME": "web"}, filterEnv(env, newGlobList("*")))
+}
+
+func TestCreateLogstashMessageWithEnv(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "f00ffd9428dc",
+			Name: "/my_db",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:4321",
+				Env:      []string{"APP_VERSION=1.2.3", "APP_SECRET=shh", "HOME=/root"},
+			},
+		},
+		Source: "stderr",
+		Data:   "cruel world",
+		Time:   time.Unix(int64(1453813310), 1000000),
+	}
+
+	opts := &messageOptions{include_env: newGlobList("APP_*")}
+
+	msg, _ := createLogstashMessage(&m, opts)
+	jq := makeQuery(msg)
+	assert.Equal("1.2.3", getString(jq, "docker", "env", "APP_VERSION"))
+	assert.Equal("", getString(jq, "docker", "env", "APP_SECRET"))
+	assert.Equal("", getString(jq, "docker", "env", "HOME"))
+
+	opts.use_v0 = true
+	msg, _ = createLogstashMessage(&m, opts)
+	jq = makeQuery(msg)
+	assert.Equal("1.2.3", getString(jq, "@fields", "docker", "env", "APP_VERSION"))
+	assert.Equal("", getString(jq, "@fields", "docker", "env", "APP_SECRET"))
+
+}
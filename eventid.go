@@ -0,0 +1,269 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	EVENT_ID_UUID = "uuid"
+	EVENT_ID_ULID = "ulid"
+
+	SEQUENCE_SAVE_INTERVAL = time.Second
+	// sequence numbers are reserved in the sequence file in blocks of this size,
+	// so they are not used again after a crash
+	SEQUENCE_RESERVE = 1000
+	// containers without events for this long are removed from the sequence file
+	SEQUENCE_MAX_AGE = 7 * 24 * time.Hour
+)
+
+// MetaFields identify an event, for deduplication and gap detection
+// downstream. Chunks of a split message share the fields of the message.
+// Adapter-generated events only have an id.
+type MetaFields struct {
+	ID              string `json:"event_id"`
+	Sequence        int64  `json:"sequence,omitempty"`
+	AdapterSequence int64  `json:"adapter_sequence,omitempty"`
+}
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	s := hex.EncodeToString(b[:])
+	return s[0:8] + "-" + s[8:12] + "-" + s[12:16] + "-" + s[16:20] + "-" + s[20:32]
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: 48 bits of milliseconds since the epoch and 80
+// random bits, as 26 characters of Crockford's base32. ULIDs sort by time.
+func newULID(now time.Time) string {
+	var b [16]byte
+	ms := uint64(now.UnixNano() / int64(time.Millisecond))
+	binary.BigEndian.PutUint64(b[0:8], ms<<16)
+	rand.Read(b[6:])
+
+	hi := binary.BigEndian.Uint64(b[0:8])
+	lo := binary.BigEndian.Uint64(b[8:16])
+	var id [26]byte
+	for i := 25; i >= 0; i-- {
+		id[i] = crockfordBase32[lo&0x1f]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(id[:])
+}
+
+// sequenceStore holds the sequence numbers of all routes, per route and per
+// container. Routes using the same sequence file share a store, so access is
+// locked. The file holds the highest sequence numbers reserved, and sequences
+// continue above them when the file is loaded.
+type sequenceStore struct {
+	mu      sync.Mutex
+	save_mu sync.Mutex
+	path    string
+	routes  map[string]*routeSequences
+	dirty   bool
+}
+
+type routeSequences struct {
+	Sequence   int64                         `json:"sequence"`
+	Reserved   int64                         `json:"reserved"`
+	Containers map[string]*containerSequence `json:"containers"`
+}
+
+type containerSequence struct {
+	Sequence int64 `json:"sequence"`
+	Reserved int64 `json:"reserved"`
+	Updated  int64 `json:"updated"`
+}
+
+var sequenceStores = struct {
+	sync.Mutex
+	stores map[string]*sequenceStore
+}{stores: make(map[string]*sequenceStore)}
+
+// openSequenceStore returns the store of the sequence file at path, loading
+// it on first use. Without a path, sequences are not persisted.
+func openSequenceStore(path string) (*sequenceStore, error) {
+	if path == "" {
+		return &sequenceStore{routes: make(map[string]*routeSequences)}, nil
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	sequenceStores.Lock()
+	defer sequenceStores.Unlock()
+	if s, ok := sequenceStores.stores[path]; ok {
+		return s, nil
+	}
+
+	s := &sequenceStore{path: path, routes: make(map[string]*routeSequences)}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(data) > 0 {
+		var file struct {
+			Routes map[string]*routeSequences `json:"routes"`
+		}
+		if err := json.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		for route, sequences := range file.Routes {
+			if sequences == nil {
+				continue
+			}
+			if sequences.Containers == nil {
+				sequences.Containers = make(map[string]*containerSequence)
+			}
+			// the sequences may have been used up to the reserved numbers
+			if sequences.Reserved > sequences.Sequence {
+				sequences.Sequence = sequences.Reserved
+			}
+			for _, c := range sequences.Containers {
+				if c != nil && c.Reserved > c.Sequence {
+					c.Sequence = c.Reserved
+				}
+			}
+			s.routes[route] = sequences
+		}
+	}
+	sequenceStores.stores[path] = s
+	return s, nil
+}
+
+// Next returns the next sequence numbers of the route and of a container of
+// the route. When the reserved numbers are used up, the next block is reserved
+// and saved before returning, so the error is that of Save.
+func (s *sequenceStore) Next(route string, container_id string, now time.Time) (container int64, adapter int64, err error) {
+	s.mu.Lock()
+	sequences := s.routes[route]
+	if sequences == nil {
+		sequences = &routeSequences{Containers: make(map[string]*containerSequence)}
+		s.routes[route] = sequences
+	}
+	c := sequences.Containers[container_id]
+	if c == nil {
+		c = &containerSequence{}
+		sequences.Containers[container_id] = c
+	}
+	sequences.Sequence++
+	c.Sequence++
+	c.Updated = now.Unix()
+	s.dirty = true
+	reserve := sequences.Sequence > sequences.Reserved || c.Sequence > c.Reserved
+	if sequences.Sequence > sequences.Reserved {
+		sequences.Reserved = sequences.Sequence + SEQUENCE_RESERVE
+	}
+	if c.Sequence > c.Reserved {
+		c.Reserved = c.Sequence + SEQUENCE_RESERVE
+	}
+	container, adapter = c.Sequence, sequences.Sequence
+	s.mu.Unlock()
+
+	if reserve {
+		err = s.Save(now)
+	}
+	return container, adapter, err
+}
+
+// Save writes the sequence file if sequences changed, and removes containers
+// without events for SEQUENCE_MAX_AGE. The file is replaced atomically.
+func (s *sequenceStore) Save(now time.Time) error {
+	if s.path == "" {
+		return nil
+	}
+	// saves of routes sharing the file must not overtake each other
+	s.save_mu.Lock()
+	defer s.save_mu.Unlock()
+
+	s.mu.Lock()
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	oldest := now.Add(-SEQUENCE_MAX_AGE).Unix()
+	for _, sequences := range s.routes {
+		for id, c := range sequences.Containers {
+			if c.Updated < oldest {
+				delete(sequences.Containers, id)
+			}
+		}
+	}
+	data, err := json.Marshal(map[string]interface{}{"routes": s.routes})
+	s.dirty = false
+	s.mu.Unlock()
+
+	if err == nil {
+		err = writeFileAtomic(s.path, data)
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// writeFileAtomic replaces the file at path, so it is never read half written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if close_err := tmp.Close(); err == nil {
+		err = close_err
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// eventStamper creates the meta fields of the events of a route. The route is
+// identified by its Redis address, database and key, as logspout does not keep
+// the ids of command line routes across restarts.
+type eventStamper struct {
+	ids       string
+	route     string
+	sequences *sequenceStore
+	log       *leveledLogger
+}
+
+// ID returns a new event id.
+func (s *eventStamper) ID(now time.Time) string {
+	if s.ids == EVENT_ID_ULID {
+		return newULID(now)
+	}
+	return newUUID()
+}
+
+func (s *eventStamper) Stamp(m *router.Message, now time.Time) *MetaFields {
+	meta := &MetaFields{ID: s.ID(now)}
+	var err error
+	meta.Sequence, meta.AdapterSequence, err = s.sequences.Next(s.route, m.Container.ID, now)
+	if err != nil {
+		s.log.Errorf("sequence_file", "redis: error saving sequences: %s\n", err)
+	}
+	return meta
+}
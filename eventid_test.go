@@ -0,0 +1,167 @@
+package redis
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	id := newUUID()
+	assert.Regexp(regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`), id)
+	assert.NotEqual(id, newUUID())
+}
+
+func TestNewULID(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	id := newULID(now)
+	assert.Len(id, 26)
+	assert.Regexp(regexp.MustCompile(`^[0-9A-HJKMNP-TV-Z]{26}$`), id)
+	// the first 10 characters hold the time
+	assert.Equal("01A9Z0D8HG", id[:10])
+	assert.NotEqual(id, newULID(now))
+	assert.True(newULID(now.Add(time.Millisecond)) > id)
+}
+
+func TestSequenceStore(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	s, err := openSequenceStore("")
+	assert.Nil(err)
+
+	container, adapter, err := s.Next("r1", "a", now)
+	assert.Nil(err)
+	assert.Equal([]int64{1, 1}, []int64{container, adapter})
+	container, adapter, _ = s.Next("r1", "b", now)
+	assert.Equal([]int64{1, 2}, []int64{container, adapter})
+	container, adapter, _ = s.Next("r1", "a", now)
+	assert.Equal([]int64{2, 3}, []int64{container, adapter})
+	container, adapter, _ = s.Next("r2", "a", now)
+	assert.Equal([]int64{1, 1}, []int64{container, adapter})
+
+	// without a file, nothing is saved
+	assert.Nil(s.Save(now))
+}
+
+func TestSequenceStorePersisted(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "sequences")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sequences.json")
+
+	now := time.Unix(1453813310, 0)
+	s, err := openSequenceStore(path)
+	assert.Nil(err)
+	// routes using the same file share the store
+	shared, _ := openSequenceStore(path)
+	assert.True(s == shared)
+
+	s.Next("r1", "a", now.Add(-8*24*time.Hour))
+	s.Next("r1", "b", now)
+	s.Next("r1", "b", now)
+	assert.Nil(s.Save(now))
+
+	// as if logspout was restarted
+	sequenceStores.Lock()
+	delete(sequenceStores.stores, path)
+	sequenceStores.Unlock()
+	s, err = openSequenceStore(path)
+	assert.Nil(err)
+	// sequences continue above the reserved numbers
+	container, adapter, err := s.Next("r1", "b", now)
+	assert.Nil(err)
+	assert.Equal([]int64{SEQUENCE_RESERVE + 2, SEQUENCE_RESERVE + 2}, []int64{container, adapter})
+	// idle containers are forgotten
+	container, _, _ = s.Next("r1", "a", now)
+	assert.Equal(int64(1), container)
+
+	ioutil.WriteFile(path, []byte("{"), 0644)
+	sequenceStores.Lock()
+	delete(sequenceStores.stores, path)
+	sequenceStores.Unlock()
+	_, err = openSequenceStore(path)
+	assert.NotNil(err)
+}
+
+func TestSequenceStoreConcurrentRoutes(t *testing.T) {
+	assert := assert.New(t)
+
+	s, _ := openSequenceStore("")
+	var wg sync.WaitGroup
+	for _, route := range []string{"r1", "r2", "r3"} {
+		wg.Add(1)
+		go func(route string) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				s.Next(route, "a", time.Now())
+			}
+		}(route)
+	}
+	wg.Wait()
+
+	container, adapter, _ := s.Next("r2", "a", time.Now())
+	assert.Equal([]int64{1001, 1001}, []int64{container, adapter})
+}
+
+func TestSequenceStoreCrash(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, _ := ioutil.TempDir("", "sequences")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "sequences.json")
+
+	now := time.Unix(1453813310, 0)
+	s, _ := openSequenceStore(path)
+	var container, adapter int64
+	for i := 0; i < SEQUENCE_RESERVE+10; i++ {
+		container, adapter, _ = s.Next("r1", "a", now)
+	}
+	assert.Equal([]int64{SEQUENCE_RESERVE + 10, SEQUENCE_RESERVE + 10}, []int64{container, adapter})
+
+	// as if logspout crashed, without saving the sequences
+	sequenceStores.Lock()
+	delete(sequenceStores.stores, path)
+	sequenceStores.Unlock()
+	s, _ = openSequenceStore(path)
+	// the sequences continue above the second block reserved
+	container, adapter, _ = s.Next("r1", "a", now)
+	assert.Equal([]int64{2*SEQUENCE_RESERVE + 3, 2*SEQUENCE_RESERVE + 3}, []int64{container, adapter})
+}
+
+func TestCreateLogstashMessageMetaFields(t *testing.T) {
+	assert := assert.New(t)
+
+	s, _ := openSequenceStore("")
+	stamper := &eventStamper{ids: EVENT_ID_UUID, route: "r1", sequences: s}
+	m := testMessage("app", "stdout", "hello", nil)
+	stamper.Stamp(m, time.Now())
+	meta := stamper.Stamp(m, time.Now())
+
+	msg, err := createLogstashMessage(m, &messageOptions{meta: meta})
+	assert.Nil(err)
+	jq := makeQuery(msg)
+	assert.Equal(meta.ID, getString(jq, "event_id"))
+	assert.Equal(2, getInt(jq, "sequence"))
+	assert.Equal(2, getInt(jq, "adapter_sequence"))
+
+	msg, _ = createLogstashMessage(m, &messageOptions{meta: meta, use_v0: true})
+	jq = makeQuery(msg)
+	assert.Equal(meta.ID, getString(jq, "@fields", "event_id"))
+	assert.Equal(2, getInt(jq, "@fields", "sequence"))
+
+	msg, _ = createLogstashMessage(m, &messageOptions{})
+	jq = makeQuery(msg)
+	assert.Equal("", getString(jq, "event_id"))
+}
@@ -0,0 +1,218 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	FILTER_DROP = "drop"
+	FILTER_KEEP = "keep"
+)
+
+// filterRule matches log lines on their content and container metadata. All
+// conditions that are set must match.
+type filterRule struct {
+	messageMatcher
+	name    string
+	action  string
+	message *regexp.Regexp
+	fields  map[string]*regexp.Regexp
+	hits    int64
+}
+
+func (r *filterRule) Match(m *router.Message, fields func() map[string]interface{}) bool {
+	if !r.Selects(m) {
+		return false
+	}
+	if r.message != nil && !r.message.MatchString(m.Data) {
+		return false
+	}
+	for path, re := range r.fields {
+		value, ok := messageString(lookupField(fields(), path))
+		if !ok || !re.MatchString(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// messageFilter decides which log lines are shipped. Rules are evaluated in
+// order and the first matching rule wins; if no rule matches, the default
+// action is taken.
+type messageFilter struct {
+	rules          []*filterRule
+	default_action string
+	json_prefix    *regexp.Regexp
+}
+
+// Ship returns whether m should be shipped, and counts the hit of the matching
+// rule.
+func (f *messageFilter) Ship(m *router.Message) bool {
+	get_fields := lazyFields(m.Data, f.json_prefix)
+	for _, rule := range f.rules {
+		if rule.Match(m, get_fields) {
+			atomic.AddInt64(&rule.hits, 1)
+			return rule.action == FILTER_KEEP
+		}
+	}
+	return f.default_action == FILTER_KEEP
+}
+
+// Counts returns the number of hits per rule.
+func (f *messageFilter) Counts() map[string]int64 {
+	counts := make(map[string]int64, len(f.rules))
+	for _, rule := range f.rules {
+		counts[rule.name] += atomic.LoadInt64(&rule.hits)
+	}
+	return counts
+}
+
+// newMessageFilter creates a filter with the rules of the filter option,
+// followed by the rules of rules_file (if set).
+func newMessageFilter(option string, rules_file string, default_action string, json_prefix *regexp.Regexp) (*messageFilter, error) {
+	if default_action != FILTER_DROP && default_action != FILTER_KEEP {
+		return nil, fmt.Errorf("unknown filter default %s", default_action)
+	}
+	f := &messageFilter{default_action: default_action, json_prefix: json_prefix}
+
+	for i, spec := range strings.Split(option, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		rule, err := parseFilterRule(fmt.Sprintf("option%d", i+1), spec)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rule)
+	}
+
+	if rules_file != "" {
+		rules, err := loadFilterRules(rules_file)
+		if err != nil {
+			return nil, err
+		}
+		f.rules = append(f.rules, rules...)
+	}
+	return f, nil
+}
+
+// parseFilterRule parses a rule of the filter option, in the form
+// '<action> <condition>=<value>', e.g. 'drop message=GET /health'.
+func parseFilterRule(name string, spec string) (*filterRule, error) {
+	parts := strings.SplitN(spec, " ", 2)
+	condition := strings.SplitN(strings.TrimSpace(parts[len(parts)-1]), "=", 2)
+	if len(parts) != 2 || len(condition) != 2 {
+		return nil, fmt.Errorf("invalid filter rule '%s', expected '<drop|keep> <condition>=<value>'", spec)
+	}
+
+	var rule_spec filterRuleSpec
+	rule_spec.Name = name
+	rule_spec.Action = parts[0]
+	key, value := condition[0], condition[1]
+	switch {
+	case key == "message":
+		rule_spec.Message = value
+	case key == "source":
+		rule_spec.Source = value
+	case key == "container":
+		rule_spec.Container = value
+	case key == "image":
+		rule_spec.Image = value
+	case key == "label":
+		rule_spec.Label = value
+	case strings.HasPrefix(key, "field."):
+		rule_spec.Fields = map[string]string{key[len("field."):]: value}
+	default:
+		return nil, fmt.Errorf("invalid filter rule '%s', unknown condition %s", spec, key)
+	}
+	return rule_spec.compile()
+}
+
+// filterRulesFile is the layout of the JSON file with filter rules, e.g.
+//
+//	{
+//	  "rules": [
+//	    {"name": "health", "action": "drop", "image": "nginx", "message": "GET /health"},
+//	    {"name": "errors", "action": "keep", "source": "stderr"},
+//	    {"name": "debug", "action": "drop", "fields": {"level": "^debug$"}}
+//	  ]
+//	}
+type filterRulesFile struct {
+	Rules []filterRuleSpec `json:"rules"`
+}
+
+type filterRuleSpec struct {
+	Name      string            `json:"name"`
+	Action    string            `json:"action"`
+	Message   string            `json:"message"`
+	Source    string            `json:"source"`
+	Container string            `json:"container"`
+	Image     string            `json:"image"`
+	Label     string            `json:"label"`
+	Fields    map[string]string `json:"fields"`
+}
+
+func (spec *filterRuleSpec) compile() (*filterRule, error) {
+	rule := &filterRule{name: spec.Name, action: spec.Action}
+	rule.source = spec.Source
+	rule.container = newGlobList(spec.Container)
+	rule.image = newGlobList(spec.Image)
+	if rule.action != FILTER_DROP && rule.action != FILTER_KEEP {
+		return nil, fmt.Errorf("rule %s has unknown action '%s'", rule.name, rule.action)
+	}
+
+	var err error
+	if spec.Message != "" {
+		rule.message, err = regexp.Compile(spec.Message)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %v", rule.name, err)
+		}
+	}
+	if spec.Label != "" {
+		rule.setLabel(spec.Label)
+	}
+	for path, expr := range spec.Fields {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: field %s: %v", rule.name, path, err)
+		}
+		if rule.fields == nil {
+			rule.fields = make(map[string]*regexp.Regexp)
+		}
+		rule.fields[path] = re
+	}
+	return rule, nil
+}
+
+func loadFilterRules(filename string) ([]*filterRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file filterRulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var rules []*filterRule
+	for i := range file.Rules {
+		spec := &file.Rules[i]
+		if spec.Name == "" {
+			spec.Name = fmt.Sprintf("rule%d", i+1)
+		}
+		rule, err := spec.compile()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", filename, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
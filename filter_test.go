@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterOption(t *testing.T) {
+	assert := assert.New(t)
+
+	f, err := newMessageFilter("drop message=GET /health; keep source=stderr ;drop image=app", "", FILTER_KEEP, nil)
+	assert.Nil(err)
+	assert.Len(f.rules, 3)
+
+	assert.False(f.Ship(testMessage("web_1", "stderr", `10.0.0.1 - - "GET /health HTTP/1.1" 200`, nil)))
+	assert.True(f.Ship(testMessage("web_1", "stderr", "oops", nil)))
+	assert.False(f.Ship(testMessage("web_1", "stdout", "GET /index.html", nil)))
+
+	assert.Equal(map[string]int64{"option1": 1, "option2": 1, "option3": 1}, f.Counts())
+
+	for _, option := range []string{"drop", "drop message", "toss message=x", "drop colour=red", "drop message=("} {
+		_, err = newMessageFilter(option, "", FILTER_KEEP, nil)
+		assert.NotNil(err, option)
+	}
+	_, err = newMessageFilter("", "", "maybe", nil)
+	assert.NotNil(err)
+}
+
+func TestFilterDefaultDrop(t *testing.T) {
+	assert := assert.New(t)
+
+	f, _ := newMessageFilter("keep label=com.example.team=ops", "", FILTER_DROP, nil)
+	assert.True(f.Ship(testMessage("web_1", "stdout", "hi", map[string]string{"com.example.team": "ops"})))
+
+	m := testMessage("web_1", "stdout", "hi", map[string]string{"com.example.team": "dev"})
+	assert.False(f.Ship(m))
+
+	f, _ = newMessageFilter("keep container=web_*", "", FILTER_DROP, nil)
+	assert.True(f.Ship(m))
+	m.Container.Name = "/db_1"
+	assert.False(f.Ship(m))
+}
+
+func TestFilterFields(t *testing.T) {
+	assert := assert.New(t)
+
+	f, _ := newMessageFilter("drop field.request.path=^/health$", "", FILTER_KEEP, nil)
+	assert.False(f.Ship(testMessage("web_1", "stdout", `{"request":{"path":"/health"}}`, nil)))
+	assert.True(f.Ship(testMessage("web_1", "stdout", `{"request":{"path":"/health/deep"}}`, nil)))
+	assert.True(f.Ship(testMessage("web_1", "stdout", `{"request":"/health"}`, nil)))
+	assert.True(f.Ship(testMessage("web_1", "stdout", `/health`, nil)))
+
+	f, _ = newMessageFilter("drop field.status=^200$", "", FILTER_KEEP, nil)
+	assert.False(f.Ship(testMessage("web_1", "stdout", `{"status":200}`, nil)))
+	assert.True(f.Ship(testMessage("web_1", "stdout", `{"status":500}`, nil)))
+}
+
+func TestLoadFilterRules(t *testing.T) {
+	assert := assert.New(t)
+
+	filename := writeTempFile(t, `{
+		"rules": [
+			{"name": "health", "action": "drop", "image": "app", "message": "GET /health"},
+			{"name": "errors", "action": "keep", "source": "stderr"},
+			{"action": "drop", "fields": {"level": "^debug$", "component": "db"}}
+		]
+	}`)
+	f, err := newMessageFilter("keep message=important", filename, FILTER_KEEP, nil)
+	assert.Nil(err)
+	assert.Len(f.rules, 4)
+
+	assert.True(f.Ship(testMessage("web_1", "stdout", "important GET /health", nil)))
+	assert.False(f.Ship(testMessage("web_1", "stdout", "GET /health", nil)))
+	assert.True(f.Ship(testMessage("web_1", "stderr", `{"level":"debug","component":"db"}`, nil)))
+	assert.False(f.Ship(testMessage("web_1", "stdout", `{"level":"debug","component":"db"}`, nil)))
+	assert.True(f.Ship(testMessage("web_1", "stdout", `{"level":"debug","component":"web"}`, nil)))
+
+	assert.Equal(map[string]int64{"option1": 1, "health": 1, "errors": 1, "rule3": 1}, f.Counts())
+
+	for _, content := range []string{
+		`{"rules": [{"name": "x", "action": "toss"}]}`,
+		`{"rules": [{"name": "x", "action": "drop", "message": "("}]}`,
+		`{"rules": [{"name": "x", "action": "drop", "fields": {"a": "("}}]}`,
+		`not json`,
+	} {
+		_, err = newMessageFilter("", writeTempFile(t, content), FILTER_KEEP, nil)
+		assert.NotNil(err, content)
+	}
+}
@@ -0,0 +1,249 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const TAG_PARSE_FAILURE = "_parse_failure"
+
+// grokBuiltins is a small, RE2 compatible, subset of the Logstash grok pattern
+// library.
+var grokBuiltins = map[string]string{
+	"USERNAME":          `[a-zA-Z0-9._-]+`,
+	"USER":              `%{USERNAME}`,
+	"INT":               `[+-]?[0-9]+`,
+	"BASE10NUM":         `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"NUMBER":            `%{BASE10NUM}`,
+	"POSINT":            `\b[1-9][0-9]*\b`,
+	"NONNEGINT":         `\b[0-9]+\b`,
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"QS":                `%{QUOTEDSTRING}`,
+	"UUID":              `[A-Fa-f0-9]{8}-(?:[A-Fa-f0-9]{4}-){3}[A-Fa-f0-9]{12}`,
+	"IPV4":              `(?:(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])\.){3}(?:25[0-5]|2[0-4][0-9]|1[0-9]{2}|[1-9]?[0-9])`,
+	"IPV6":              `(?:[0-9A-Fa-f]{0,4}:){2,7}(?:%{IPV4}|[0-9A-Fa-f]{0,4})`,
+	"IP":                `(?:%{IPV6}|%{IPV4})`,
+	"HOSTNAME":          `\b[0-9A-Za-z][0-9A-Za-z-]{0,62}(?:\.[0-9A-Za-z][0-9A-Za-z-]{0,62})*\.?\b`,
+	"IPORHOST":          `(?:%{IP}|%{HOSTNAME})`,
+	"HOSTPORT":          `%{IPORHOST}:%{POSINT}`,
+	"UNIXPATH":          `(?:/[^/\s]*)+`,
+	"URIPATH":           `(?:/[A-Za-z0-9$.+!*'(){},~:;=@#%&_\-]*)+`,
+	"URIPARAM":          `\?[A-Za-z0-9$.+!*'|(){},~@#%&/=:;_?\-\[\]<>]*`,
+	"URIPATHPARAM":      `%{URIPATH}(?:%{URIPARAM})?`,
+	"MONTH":             `\b(?:[Jj]an(?:uary)?|[Ff]eb(?:ruary)?|[Mm]ar(?:ch)?|[Aa]pr(?:il)?|[Mm]ay|[Jj]un(?:e)?|[Jj]ul(?:y)?|[Aa]ug(?:ust)?|[Ss]ep(?:tember)?|[Oo]ct(?:ober)?|[Nn]ov(?:ember)?|[Dd]ec(?:ember)?)\b`,
+	"MONTHNUM":          `(?:0?[1-9]|1[0-2])`,
+	"MONTHDAY":          `(?:0[1-9]|[12][0-9]|3[01]|[1-9])`,
+	"YEAR":              `(?:\d\d){1,2}`,
+	"HOUR":              `(?:2[0123]|[01]?[0-9])`,
+	"MINUTE":            `(?:[0-5][0-9])`,
+	"SECOND":            `(?:(?:[0-5]?[0-9]|60)(?:[:.,][0-9]+)?)`,
+	"TIME":              `%{HOUR}:%{MINUTE}:%{SECOND}`,
+	"ISO8601_TIMEZONE":  `(?:Z|[+-]%{HOUR}(?::?%{MINUTE}))`,
+	"TIMESTAMP_ISO8601": `%{YEAR}-%{MONTHNUM}-%{MONTHDAY}[T ]%{HOUR}:?%{MINUTE}(?::?%{SECOND})?%{ISO8601_TIMEZONE}?`,
+	"HTTPDATE":          `%{MONTHDAY}/%{MONTH}/%{YEAR}:%{TIME} %{INT}`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|severe|emerg(?:ency)?|alert|log)`,
+	"COMMONAPACHELOG":   `%{IPORHOST:clientip} %{USER:ident} %{USER:auth} \[%{HTTPDATE:timestamp}\] "(?:%{WORD:verb} %{NOTSPACE:request}(?: HTTP/%{NUMBER:httpversion})?|%{DATA:rawrequest})" %{NUMBER:response:int} (?:%{NUMBER:bytes:int}|-)`,
+	"COMBINEDAPACHELOG": `%{COMMONAPACHELOG} %{QS:referrer} %{QS:agent}`,
+	"NGINXACCESS":       `%{COMBINEDAPACHELOG}`,
+	"NGINXERROR":        `(?P<timestamp>%{YEAR}/%{MONTHNUM}/%{MONTHDAY} %{TIME}) \[%{LOGLEVEL:level}\] %{POSINT:pid:int}#%{NONNEGINT:tid:int}: (?:\*%{NONNEGINT:connection_id:int} )?%{GREEDYDATA:message}`,
+	"POSTGRESQL":        `%{TIMESTAMP_ISO8601:timestamp}(?: %{WORD:timezone})? \[%{POSINT:pid:int}\](?: %{USERNAME:user}@%{USERNAME:database})? %{WORD:level}:  %{GREEDYDATA:message}`,
+}
+
+var grokReference = regexp.MustCompile(`%\{(\w+)(?::([\w.\-\[\]@]+))?(?::(int|float))?\}`)
+
+type grokField struct {
+	name string
+	typ  string
+}
+
+// grokPattern is a compiled grok expression. Fields are captured by group
+// index, so field names are not restricted to what regexp allows in group names.
+type grokPattern struct {
+	re     *regexp.Regexp
+	fields map[int]grokField
+}
+
+// compileGrok expands the %{PATTERN}, %{PATTERN:field} and %{PATTERN:field:type}
+// references in expr using library, and compiles the result. Regular named
+// groups (?P<field>...) are captured too.
+func compileGrok(expr string, library map[string]string) (*grokPattern, error) {
+	var names []grokField
+	expanded, err := expandGrok(expr, library, &names, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, err
+	}
+
+	pattern := &grokPattern{re: re, fields: make(map[int]grokField)}
+	for i, name := range re.SubexpNames() {
+		if strings.HasPrefix(name, "grok") {
+			if n, err := strconv.Atoi(name[4:]); err == nil && n < len(names) {
+				pattern.fields[i] = names[n]
+				continue
+			}
+		}
+		if name != "" {
+			pattern.fields[i] = grokField{name: name}
+		}
+	}
+	return pattern, nil
+}
+
+func expandGrok(expr string, library map[string]string, names *[]grokField, depth int) (string, error) {
+	if depth > 20 {
+		return "", fmt.Errorf("grok patterns nested too deep (recursive definition?)")
+	}
+
+	var err error
+	expanded := grokReference.ReplaceAllStringFunc(expr, func(ref string) string {
+		parts := grokReference.FindStringSubmatch(ref)
+		definition, ok := library[parts[1]]
+		if !ok {
+			definition, ok = grokBuiltins[parts[1]]
+		}
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %s", parts[1])
+			return ""
+		}
+		inner, e := expandGrok(definition, library, names, depth+1)
+		if e != nil {
+			err = e
+			return ""
+		}
+		if parts[2] == "" {
+			return "(?:" + inner + ")"
+		}
+		*names = append(*names, grokField{name: parts[2], typ: parts[3]})
+		return fmt.Sprintf("(?P<grok%d>%s)", len(*names)-1, inner)
+	})
+	return expanded, err
+}
+
+// Match returns the captured fields, skipping groups that did not participate
+// in the match or captured an empty string.
+func (p *grokPattern) Match(line string) (map[string]interface{}, bool) {
+	loc := p.re.FindStringSubmatchIndex(line)
+	if loc == nil {
+		return nil, false
+	}
+
+	fields := make(map[string]interface{})
+	for i, field := range p.fields {
+		start, end := loc[2*i], loc[2*i+1]
+		if start < 0 || start == end {
+			continue
+		}
+		value := line[start:end]
+		switch field.typ {
+		case "int":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				fields[field.name] = n
+				continue
+			}
+		case "float":
+			if f, err := strconv.ParseFloat(value, 64); err == nil {
+				fields[field.name] = f
+				continue
+			}
+		}
+		fields[field.name] = value
+	}
+	return fields, true
+}
+
+// grokRule selects containers by image and/or label, and holds the patterns
+// tried (in order) on their log lines.
+type grokRule struct {
+	messageMatcher
+	name     string
+	patterns []*grokPattern
+}
+
+func (r *grokRule) Match(line string) (map[string]interface{}, bool) {
+	for _, pattern := range r.patterns {
+		if fields, ok := pattern.Match(line); ok {
+			return fields, true
+		}
+	}
+	return nil, false
+}
+
+type grokRules []*grokRule
+
+// Select returns the first rule that selects the container of m, or nil.
+func (rules grokRules) Select(m *router.Message) *grokRule {
+	for _, rule := range rules {
+		if rule.Selects(m) {
+			return rule
+		}
+	}
+	return nil
+}
+
+// grokRulesFile is the layout of the JSON file with grok rules, e.g.
+//
+//	{
+//	  "patterns": {"MYLEVEL": "(?:INFO|WARN)"},
+//	  "rules": [
+//	    {"name": "nginx", "image": "nginx", "match": ["%{NGINXACCESS}", "%{NGINXERROR}"]},
+//	    {"name": "app", "label": "com.example.format=app", "match": ["%{MYLEVEL:level} %{GREEDYDATA:message}"]}
+//	  ]
+//	}
+type grokRulesFile struct {
+	Patterns map[string]string `json:"patterns"`
+	Rules    []struct {
+		Name  string   `json:"name"`
+		Image string   `json:"image"`
+		Label string   `json:"label"`
+		Match []string `json:"match"`
+	} `json:"rules"`
+}
+
+func loadGrokRules(filename string) (grokRules, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file grokRulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var rules grokRules
+	for i, r := range file.Rules {
+		rule := &grokRule{name: r.Name}
+		rule.image = newGlobList(r.Image)
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule%d", i+1)
+		}
+		if r.Label != "" {
+			rule.setLabel(r.Label)
+		}
+		if len(r.Match) == 0 {
+			return nil, fmt.Errorf("%s: rule %s has no match patterns", filename, rule.name)
+		}
+		for _, expr := range r.Match {
+			pattern, err := compileGrok(expr, file.Patterns)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %s: %v", filename, rule.name, err)
+			}
+			rule.patterns = append(rule.patterns, pattern)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
@@ -0,0 +1,224 @@
+package redis
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCompileGrok(t *testing.T) {
+	assert := assert.New(t)
+
+	pattern, err := compileGrok(`%{MYLEVEL:[app][level]} (?P<code>\d+) %{INT:took.ms:int}ms %{NUMBER:ratio:float} %{GREEDYDATA:message}`,
+		map[string]string{"MYLEVEL": "(?:INFO|WARN)"})
+	assert.Nil(err)
+
+	fields, ok := pattern.Match("WARN 503 120ms 0.25 upstream timed out")
+	assert.True(ok)
+	assert.Equal(map[string]interface{}{
+		"[app][level]": "WARN",
+		"code":         "503",
+		"took.ms":      int64(120),
+		"ratio":        0.25,
+		"message":      "upstream timed out",
+	}, fields)
+
+	_, ok = pattern.Match("DEBUG 503 120ms 0.25 upstream timed out")
+	assert.False(ok)
+
+	_, err = compileGrok(`%{NOSUCHPATTERN:x}`, nil)
+	assert.NotNil(err)
+
+	_, err = compileGrok(`%{A}`, map[string]string{"A": "%{B}", "B": "%{A}"})
+	assert.NotNil(err)
+
+	_, err = compileGrok(`(unbalanced`, nil)
+	assert.NotNil(err)
+}
+
+func TestGrokBuiltinsCompile(t *testing.T) {
+	assert := assert.New(t)
+
+	for name := range grokBuiltins {
+		_, err := compileGrok("%{"+name+"}", nil)
+		assert.Nil(err, name)
+	}
+}
+
+func TestGrokNginxAccess(t *testing.T) {
+	assert := assert.New(t)
+
+	pattern, _ := compileGrok("%{NGINXACCESS}", nil)
+	fields, ok := pattern.Match(`172.17.0.1 - - [16/Oct/2016:10:00:00 +0000] "GET /index.html?a=b HTTP/1.1" 200 612 "-" "curl/7.50.1"`)
+	assert.True(ok)
+	assert.Equal("172.17.0.1", fields["clientip"])
+	assert.Equal("16/Oct/2016:10:00:00 +0000", fields["timestamp"])
+	assert.Equal("GET", fields["verb"])
+	assert.Equal("/index.html?a=b", fields["request"])
+	assert.Equal("1.1", fields["httpversion"])
+	assert.Equal(int64(200), fields["response"])
+	assert.Equal(int64(612), fields["bytes"])
+	assert.Equal(`"curl/7.50.1"`, fields["agent"])
+	_, ok = fields["rawrequest"]
+	assert.False(ok)
+
+	fields, ok = pattern.Match(`::1 - bob [16/Oct/2016:10:00:00 +0000] "-" 400 - "-" "-"`)
+	assert.True(ok)
+	assert.Equal("::1", fields["clientip"])
+	assert.Equal("bob", fields["auth"])
+	assert.Equal("-", fields["rawrequest"])
+	_, ok = fields["bytes"]
+	assert.False(ok)
+}
+
+func TestGrokNginxErrorAndPostgres(t *testing.T) {
+	assert := assert.New(t)
+
+	pattern, _ := compileGrok("%{NGINXERROR}", nil)
+	fields, ok := pattern.Match(`2016/10/16 10:00:00 [error] 7#7: *1 open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory)`)
+	assert.True(ok)
+	assert.Equal("2016/10/16 10:00:00", fields["timestamp"])
+	assert.Equal("error", fields["level"])
+	assert.Equal(int64(7), fields["pid"])
+	assert.Equal(int64(1), fields["connection_id"])
+	assert.Equal(`open() "/usr/share/nginx/html/favicon.ico" failed (2: No such file or directory)`, fields["message"])
+
+	pattern, _ = compileGrok("%{POSTGRESQL}", nil)
+	fields, ok = pattern.Match(`2016-10-16 10:00:00.123 UTC [42] app@shop ERROR:  relation "foo" does not exist`)
+	assert.True(ok)
+	assert.Equal("2016-10-16 10:00:00.123", fields["timestamp"])
+	assert.Equal("UTC", fields["timezone"])
+	assert.Equal(int64(42), fields["pid"])
+	assert.Equal("app", fields["user"])
+	assert.Equal("shop", fields["database"])
+	assert.Equal("ERROR", fields["level"])
+	assert.Equal(`relation "foo" does not exist`, fields["message"])
+}
+
+func TestGrokRuleSelects(t *testing.T) {
+	assert := assert.New(t)
+
+	image := func(image string) *router.Message {
+		m := testMessage("a", "stdout", "", nil)
+		m.Container.Config.Image = image
+		return m
+	}
+	rule := &grokRule{}
+	rule.image = newGlobList("nginx,*/nginx")
+	assert.True(rule.Selects(image("nginx")))
+	assert.True(rule.Selects(image("nginx:1.11")))
+	assert.True(rule.Selects(image("my.registry.host:443/library/nginx:1.11")))
+	assert.False(rule.Selects(image("nginx-exporter:latest")))
+
+	rule = &grokRule{}
+	rule.setLabel("com.example.format=nginx*")
+	assert.True(rule.Selects(testMessage("a", "stdout", "", map[string]string{"com.example.format": "nginx-combined"})))
+	assert.False(rule.Selects(testMessage("a", "stdout", "", map[string]string{"com.example.format": "app"})))
+	assert.False(rule.Selects(testMessage("a", "stdout", "", nil)))
+
+	rule = &grokRule{}
+	rule.setLabel("com.example.parse")
+	assert.True(rule.Selects(testMessage("a", "stdout", "", map[string]string{"com.example.parse": ""})))
+}
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "logspout-redis-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestLoadGrokRules(t *testing.T) {
+	assert := assert.New(t)
+
+	filename := writeTempFile(t, `{
+		"patterns": {"APPLINE": "%{LOGLEVEL:level} %{GREEDYDATA:message}"},
+		"rules": [
+			{"name": "nginx", "image": "nginx", "match": ["%{NGINXACCESS}", "%{NGINXERROR}"]},
+			{"label": "com.example.format=app", "match": ["%{APPLINE}"]}
+		]
+	}`)
+	defer os.Remove(filename)
+
+	rules, err := loadGrokRules(filename)
+	assert.Nil(err)
+	assert.Len(rules, 2)
+	assert.Equal("nginx", rules[0].name)
+	assert.Len(rules[0].patterns, 2)
+	assert.Equal("rule2", rules[1].name)
+	assert.Equal("com.example.format", rules[1].label_key)
+
+	for _, content := range []string{
+		`not json`,
+		`{"rules": [{"name": "empty", "image": "nginx"}]}`,
+		`{"rules": [{"name": "bad", "match": ["%{NOPE}"]}]}`,
+	} {
+		filename := writeTempFile(t, content)
+		_, err = loadGrokRules(filename)
+		assert.NotNil(err, content)
+		os.Remove(filename)
+	}
+
+	_, err = loadGrokRules("/non/existing/file")
+	assert.NotNil(err)
+}
+
+func TestCreateLogstashMessageWithGrokRules(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/web",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "nginx:1.11",
+			},
+		},
+		Source: "stdout",
+		Data:   `172.17.0.1 - - [16/Oct/2016:10:00:00 +0000] "GET /health HTTP/1.1" 200 2 "-" "kube-probe/1.4"`,
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	pattern, _ := compileGrok("%{NGINXACCESS}", nil)
+	opts := &messageOptions{grok_rules: grokRules{&grokRule{messageMatcher: messageMatcher{image: newGlobList("nginx")}, name: "nginx", patterns: []*grokPattern{pattern}}}}
+
+	msg, _ := createLogstashMessage(&m, opts)
+	jq := makeQuery(msg)
+
+	assert.Equal(m.Data, getString(jq, "message"))
+	assert.Equal("/health", getString(jq, "event", "request"))
+	assert.Equal(200, getInt(jq, "event", "response"))
+	_, err := jq.Array("tags")
+	assert.NotNil(err)
+
+	// no pattern matches
+	m.Data = "nginx: [emerg] unknown directive"
+	msg, _ = createLogstashMessage(&m, opts)
+	jq = makeQuery(msg)
+
+	assert.Equal(m.Data, getString(jq, "message"))
+	tags, _ := jq.ArrayOfStrings("tags")
+	assert.Equal([]string{"_parse_failure"}, tags)
+
+	// rule does not select the container
+	m.Container.Config.Image = "postgres:9.6"
+	msg, _ = createLogstashMessage(&m, opts)
+	jq = makeQuery(msg)
+
+	assert.Equal(m.Data, getString(jq, "message"))
+	_, err = jq.Array("tags")
+	assert.NotNil(err)
+
+}
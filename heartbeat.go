@@ -0,0 +1,187 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const LOGTYPE_HEARTBEAT = "heartbeat"
+
+// Version of the adapter, set at build time (see build.sh).
+var Version = "dev"
+
+// heartbeatCounters are the adapter counters reported in a heartbeat.
+type heartbeatCounters struct {
+	MessagesReceived int64 `json:"messages_received"`
+	EventsPushed     int64 `json:"events_pushed"`
+	BytesPushed      int64 `json:"bytes_pushed"`
+	Dropped          int64 `json:"dropped"`
+	MarshalErrors    int64 `json:"marshal_errors"`
+	PushErrors       int64 `json:"push_errors"`
+}
+
+func (c heartbeatCounters) Sub(previous heartbeatCounters) heartbeatCounters {
+	return heartbeatCounters{
+		MessagesReceived: c.MessagesReceived - previous.MessagesReceived,
+		EventsPushed:     c.EventsPushed - previous.EventsPushed,
+		BytesPushed:      c.BytesPushed - previous.BytesPushed,
+		Dropped:          c.Dropped - previous.Dropped,
+		MarshalErrors:    c.MarshalErrors - previous.MarshalErrors,
+		PushErrors:       c.PushErrors - previous.PushErrors,
+	}
+}
+
+// heartbeat creates the periodic heartbeat log lines of a route, with the
+// counters, the running containers and the containers that logged since the
+// previous heartbeat. Apart from the running containers, it is only used by
+// Stream.
+type heartbeat struct {
+	interval time.Duration
+	key      string
+	hostname string
+	metrics  *adapterMetrics
+	previous heartbeatCounters
+	logged   map[string]bool
+	log      *leveledLogger
+	// list returns the names of the running containers, it may block
+	list func() ([]string, error)
+
+	mu      sync.Mutex
+	running []string
+	listed  time.Time
+	listing bool
+}
+
+func newHeartbeat(interval time.Duration, key string, metrics *adapterMetrics) (*heartbeat, error) {
+	client, err := docker.NewClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	hostname, _ := os.Hostname()
+	return &heartbeat{
+		interval: interval,
+		key:      key,
+		hostname: hostname,
+		metrics:  metrics,
+		logged:   make(map[string]bool),
+		log:      defaultLogger,
+		list: func() ([]string, error) {
+			return runningContainers(client)
+		},
+	}, nil
+}
+
+// runningContainers returns the names of the running containers. logspout
+// attaches to these, unless it ignores them or the route filters them out.
+func runningContainers(client *docker.Client) ([]string, error) {
+	containers, err := client.ListContainers(docker.ListContainersOptions{})
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(containers))
+	for _, c := range containers {
+		if len(c.Names) > 0 {
+			names = append(names, strings.TrimPrefix(c.Names[0], "/"))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Refresh lists the running containers in the background, unless the previous
+// listing is still running, so a slow Docker daemon never delays shipping.
+func (h *heartbeat) Refresh() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.listing {
+		return
+	}
+	h.listing = true
+
+	go func() {
+		running, err := h.list()
+		if err != nil {
+			h.log.Errorf("heartbeat_containers", "redis: unable to list the running containers: %v", err)
+		}
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.listing = false
+		if err == nil {
+			h.running = running
+			h.listed = time.Now()
+		}
+	}()
+}
+
+// Running returns the running containers of the last listing, or nil if there
+// was no successful listing in the last two intervals.
+func (h *heartbeat) Running(now time.Time) []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.listed.IsZero() || now.Sub(h.listed) > 2*h.interval {
+		return nil
+	}
+	return h.running
+}
+
+// Seen records the container of a received log line.
+func (h *heartbeat) Seen(m *router.Message) {
+	h.logged[strings.TrimPrefix(m.Container.Name, "/")] = true
+}
+
+func (h *heartbeat) counters() heartbeatCounters {
+	m := h.metrics
+	counters := heartbeatCounters{
+		MessagesReceived: atomic.LoadInt64(&m.messages_in),
+		EventsPushed:     atomic.LoadInt64(&m.events_out),
+		BytesPushed:      atomic.LoadInt64(&m.bytes_out),
+		MarshalErrors:    atomic.LoadInt64(&m.marshal_errors),
+		PushErrors:       atomic.LoadInt64(&m.push_errors),
+	}
+	for _, reason := range dropReasons {
+		counters.Dropped += atomic.LoadInt64(m.dropped[reason])
+	}
+	return counters
+}
+
+// Message returns the heartbeat log line with the running containers, and
+// resets the counters and the containers that logged. running is nil when the
+// containers could not be listed.
+func (h *heartbeat) Message(now time.Time, running []string) *router.Message {
+	logged := make([]string, 0, len(h.logged))
+	for name := range h.logged {
+		logged = append(logged, name)
+	}
+	sort.Strings(logged)
+	h.logged = make(map[string]bool)
+
+	counters := h.counters()
+	data, _ := json.Marshal(map[string]interface{}{
+		"message":            fmt.Sprintf("heartbeat of %s, %d containers running, %d logged in the last %s", h.hostname, len(running), len(logged), h.interval),
+		"version":            Version,
+		"interval":           h.interval.Seconds(),
+		"counters":           counters.Sub(h.previous),
+		"running_containers": running,
+		"logged_containers":  logged,
+	})
+	h.previous = counters
+
+	return &router.Message{
+		Container: &docker.Container{
+			Name:   SOURCE_LOGSPOUT,
+			Config: &docker.Config{Hostname: h.hostname},
+		},
+		Source: SOURCE_LOGSPOUT,
+		Data:   string(data),
+		Time:   now,
+	}
+}
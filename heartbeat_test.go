@@ -0,0 +1,109 @@
+package redis
+
+import (
+	"errors"
+	"io/ioutil"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHeartbeat(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	metrics := newAdapterMetrics("r1", "redis:6379")
+	h, err := newHeartbeat(time.Minute, "heartbeats", metrics)
+	assert.Nil(err)
+	h.hostname = "node-1"
+
+	metrics.messages_in = 3
+	metrics.Pushed(100, time.Millisecond)
+	metrics.Drop(DROP_FILTERED, 1)
+	h.Seen(testMessage("web", "stdout", "hello", nil))
+	h.Seen(testMessage("db", "stdout", "hello", nil))
+	h.Seen(testMessage("web", "stdout", "hello", nil))
+
+	m := h.Message(now, []string{"cron", "db", "web"})
+	assert.Equal(SOURCE_LOGSPOUT, m.Source)
+	assert.Equal(now, m.Time)
+
+	opts := &messageOptions{docker_host: "docker-1", logtype: LOGTYPE_HEARTBEAT}
+	msg, err := createSyntheticMessage(m, opts)
+	assert.Nil(err)
+	jq := makeQuery(msg)
+	assert.Equal("heartbeat of node-1, 3 containers running, 2 logged in the last 1m0s", getString(jq, "message"))
+	assert.Equal(LOGTYPE_HEARTBEAT, getString(jq, "logtype"))
+	assert.Equal("node-1", getString(jq, "host"))
+	assert.Equal("docker-1", getString(jq, "docker", "docker_host"))
+	assert.Equal(Version, getString(jq, "heartbeat", "version"))
+	assert.Equal(60, getInt(jq, "heartbeat", "interval"))
+	assert.Equal(3, getInt(jq, "heartbeat", "counters", "messages_received"))
+	assert.Equal(1, getInt(jq, "heartbeat", "counters", "events_pushed"))
+	assert.Equal(100, getInt(jq, "heartbeat", "counters", "bytes_pushed"))
+	assert.Equal(1, getInt(jq, "heartbeat", "counters", "dropped"))
+	containers, _ := jq.ArrayOfStrings("heartbeat", "running_containers")
+	assert.Equal([]string{"cron", "db", "web"}, containers)
+	containers, _ = jq.ArrayOfStrings("heartbeat", "logged_containers")
+	assert.Equal([]string{"db", "web"}, containers)
+
+	// counters and logged containers are reset, quiet containers are still running
+	metrics.messages_in = 5
+	msg, _ = createSyntheticMessage(h.Message(now.Add(time.Minute), []string{"cron", "db", "web"}), opts)
+	jq = makeQuery(msg)
+	assert.Equal(2, getInt(jq, "heartbeat", "counters", "messages_received"))
+	assert.Equal(0, getInt(jq, "heartbeat", "counters", "events_pushed"))
+	containers, _ = jq.ArrayOfStrings("heartbeat", "running_containers")
+	assert.Equal([]string{"cron", "db", "web"}, containers)
+	containers, _ = jq.ArrayOfStrings("heartbeat", "logged_containers")
+	assert.Equal([]string{}, containers)
+}
+
+func TestHeartbeatRefresh(t *testing.T) {
+	assert := assert.New(t)
+
+	h, _ := newHeartbeat(time.Minute, "heartbeats", newAdapterMetrics("r1", "redis:6379"))
+	h.log, _ = newLeveledLogger("error", LOG_FORMAT_TEXT, 0, "")
+	h.log.out = ioutil.Discard
+	listed := make(chan bool)
+	var calls int32
+	h.list = func() ([]string, error) {
+		atomic.AddInt32(&calls, 1)
+		<-listed
+		return []string{"db", "web"}, nil
+	}
+
+	// a slow Docker daemon does not block, nor start more listings
+	h.Refresh()
+	h.Refresh()
+	assert.Nil(h.Running(time.Now()))
+	listed <- true
+	for h.Running(time.Now()) == nil {
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal([]string{"db", "web"}, h.Running(time.Now()))
+	assert.Equal(int32(1), atomic.LoadInt32(&calls))
+
+	// failed listings keep the last one, until it is too old
+	h.list = func() ([]string, error) { return nil, errors.New("docker is down") }
+	h.Refresh()
+	assert.Equal([]string{"db", "web"}, h.Running(time.Now()))
+	assert.Nil(h.Running(time.Now().Add(3 * time.Minute)))
+}
+
+func TestHeartbeatV0(t *testing.T) {
+	assert := assert.New(t)
+
+	metrics := newAdapterMetrics("r1", "redis:6379")
+	h, err := newHeartbeat(time.Minute, "heartbeats", metrics)
+	assert.Nil(err)
+
+	opts := &messageOptions{use_v0: true, logtype: LOGTYPE_HEARTBEAT}
+	msg, err := createSyntheticMessage(h.Message(time.Unix(1453813310, 0), nil), opts)
+	assert.Nil(err)
+	jq := makeQuery(msg)
+	assert.Equal(LOGTYPE_HEARTBEAT, getString(jq, "@fields", "logtype"))
+	assert.Equal(SOURCE_LOGSPOUT, getString(jq, "@fields", "docker", "source"))
+}
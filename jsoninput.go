@@ -0,0 +1,110 @@
+package redis
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	JSON_ARRAYS_SPLIT        = "split"
+	JSON_ARRAYS_FIELD        = "field"
+	DEFAULT_JSON_ARRAY_FIELD = "items"
+)
+
+// findJSON returns the JSON object or array in a log line. A UTF-8 BOM and
+// surrounding whitespace (including a trailing CR) are ignored. If prefix is
+// set and matches the start of the line, the JSON may follow the prefix; the
+// named groups of prefix are returned as fields.
+func findJSON(data string, prefix *regexp.Regexp) (string, map[string]interface{}, bool) {
+	s := strings.TrimSpace(strings.TrimPrefix(data, "\ufeff"))
+	if validJsonMessage(s) || validJsonArray(s) {
+		return s, nil, true
+	}
+	if prefix == nil {
+		return "", nil, false
+	}
+
+	loc := prefix.FindStringSubmatchIndex(s)
+	if loc == nil || loc[0] != 0 {
+		return "", nil, false
+	}
+	rest := strings.TrimSpace(s[loc[1]:])
+	if !validJsonMessage(rest) && !validJsonArray(rest) {
+		return "", nil, false
+	}
+
+	var fields map[string]interface{}
+	for i, name := range prefix.SubexpNames() {
+		if name == "" || loc[2*i] < 0 {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]interface{})
+		}
+		fields[name] = s[loc[2*i]:loc[2*i+1]]
+	}
+	return rest, fields, true
+}
+
+func validJsonArray(s string) bool {
+	return strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]")
+}
+
+// splitJSONArrays returns a channel on which log lines holding a JSON array
+// are replaced by a log line for each element of the array. If overrides is
+// set, log lines of containers using layout v0 are not split, as JSON input is
+// not supported with v0.
+func splitJSONArrays(in chan *router.Message, prefix *regexp.Regexp, overrides *overrideCache) chan *router.Message {
+	out := make(chan *router.Message)
+
+	go func() {
+		defer close(out)
+		for m := range in {
+			if overrides != nil && overrides.Get(m).opts.use_v0 {
+				out <- m
+				continue
+			}
+			for _, element := range splitJSONArray(m, prefix) {
+				out <- element
+			}
+		}
+	}()
+
+	return out
+}
+
+func splitJSONArray(m *router.Message, prefix *regexp.Regexp) []*router.Message {
+	js, prefix_fields, ok := findJSON(m.Data, prefix)
+	if !ok || !validJsonArray(js) {
+		return []*router.Message{m}
+	}
+	var elements []interface{}
+	if err := json.Unmarshal([]byte(js), &elements); err != nil || len(elements) == 0 {
+		return []*router.Message{m}
+	}
+
+	messages := make([]*router.Message, 0, len(elements))
+	for _, element := range elements {
+		split := *m
+		switch v := element.(type) {
+		case string:
+			split.Data = v
+		case map[string]interface{}:
+			for key, value := range prefix_fields {
+				if _, ok := v[key]; !ok {
+					v[key] = value
+				}
+			}
+			data, _ := json.Marshal(v)
+			split.Data = string(data)
+		default:
+			data, _ := json.Marshal(v)
+			split.Data = string(data)
+		}
+		messages = append(messages, &split)
+	}
+	return messages
+}
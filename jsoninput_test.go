@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	js, fields, ok := findJSON(`{"message":"foo"}`, nil)
+	assert.True(ok)
+	assert.Equal(`{"message":"foo"}`, js)
+	assert.Nil(fields)
+
+	js, _, ok = findJSON("\ufeff{\"message\":\"foo\"}\r", nil)
+	assert.True(ok)
+	assert.Equal(`{"message":"foo"}`, js)
+
+	js, _, ok = findJSON(" [1, 2]\r\n", nil)
+	assert.True(ok)
+	assert.Equal(`[1, 2]`, js)
+
+	_, _, ok = findJSON(`2016-10-16T10:00:00Z INFO {"message":"foo"}`, nil)
+	assert.False(ok)
+
+	_, _, ok = findJSON(`hello world`, nil)
+	assert.False(ok)
+}
+
+func TestFindJSONWithPrefix(t *testing.T) {
+	assert := assert.New(t)
+
+	prefix := regexp.MustCompile(`^(?P<time>\S+) (?P<level>[A-Z]+)(?P<unused>!)? `)
+
+	js, fields, ok := findJSON(`2016-10-16T10:00:00Z INFO {"message":"foo"}`+"\r", prefix)
+	assert.True(ok)
+	assert.Equal(`{"message":"foo"}`, js)
+	assert.Equal(map[string]interface{}{"time": "2016-10-16T10:00:00Z", "level": "INFO"}, fields)
+
+	js, fields, ok = findJSON(`{"message":"foo"}`, prefix)
+	assert.True(ok)
+	assert.Equal(`{"message":"foo"}`, js)
+	assert.Nil(fields)
+
+	_, _, ok = findJSON(`2016-10-16T10:00:00Z INFO not json`, prefix)
+	assert.False(ok)
+
+	// prefix must match at the start of the line
+	_, _, ok = findJSON(`x 2016-10-16T10:00:00Z INFO {"message":"foo"}`, regexp.MustCompile(`\S+Z INFO `))
+	assert.False(ok)
+}
+
+func TestSplitJSONArray(t *testing.T) {
+	assert := assert.New(t)
+
+	m := &router.Message{
+		Container: &docker.Container{ID: "6feffd9428dc", Config: &docker.Config{}},
+		Source:    "stdout",
+		Data:      `[{"message":"a"}, "b", 3, [4], {"time":"own"}]`,
+	}
+
+	var data []string
+	for _, split := range splitJSONArray(m, nil) {
+		assert.Equal(m.Container, split.Container)
+		assert.Equal("stdout", split.Source)
+		data = append(data, split.Data)
+	}
+	assert.Equal([]string{`{"message":"a"}`, "b", "3", "[4]", `{"time":"own"}`}, data)
+
+	// prefix fields are added to objects
+	data = nil
+	m.Data = `12:00 [{"message":"a"}, {"time":"own"}]`
+	for _, split := range splitJSONArray(m, regexp.MustCompile(`^(?P<time>\S+) `)) {
+		data = append(data, split.Data)
+	}
+	assert.Equal([]string{`{"message":"a","time":"12:00"}`, `{"time":"own"}`}, data)
+
+	for _, input := range []string{`[]`, `[invalid`, `[1, 2`, `{"message":"a"}`, `plain`} {
+		m.Data = input
+		assert.Equal([]*router.Message{m}, splitJSONArray(m, nil), input)
+	}
+}
+
+func TestSplitJSONArrays(t *testing.T) {
+	assert := assert.New(t)
+
+	in := make(chan *router.Message)
+	out := splitJSONArrays(in, nil, nil)
+	go func() {
+		in <- &router.Message{Data: `["a","b"]`}
+		in <- &router.Message{Data: `c`}
+		close(in)
+	}()
+
+	var data []string
+	for m := range out {
+		data = append(data, m.Data)
+	}
+	assert.Equal([]string{"a", "b", "c"}, data)
+}
+
+func TestSplitJSONArraysLayoutOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	overrides := newOverrideCache("logspout", &messageOptions{json_arrays: JSON_ARRAYS_SPLIT})
+	in := make(chan *router.Message)
+	out := splitJSONArrays(in, nil, overrides)
+	go func() {
+		in <- testMessage("a", "stdout", `["a","b"]`, map[string]string{LABEL_LAYOUT: LAYOUT_V0})
+		in <- testMessage("b", "stdout", `["c","d"]`, nil)
+		close(in)
+	}()
+
+	var data []string
+	for m := range out {
+		data = append(data, m.Data)
+	}
+	// containers using layout v0 are not split
+	assert.Equal([]string{`["a","b"]`, "c", "d"}, data)
+
+	// nor are all containers of a v0 route, unless they select v1
+	overrides = newOverrideCache("logspout", &messageOptions{json_arrays: JSON_ARRAYS_SPLIT, use_v0: true})
+	in = make(chan *router.Message)
+	out = splitJSONArrays(in, nil, overrides)
+	go func() {
+		in <- testMessage("a", "stdout", `["a","b"]`, map[string]string{LABEL_LAYOUT: LAYOUT_V1})
+		in <- testMessage("b", "stdout", `["c","d"]`, nil)
+		close(in)
+	}()
+
+	data = nil
+	for m := range out {
+		data = append(data, m.Data)
+	}
+	assert.Equal([]string{"a", "b", `["c","d"]`}, data)
+}
+
+func TestCreateLogstashMessageWithJsonPrefixBomAndArrays(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/my_app",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:1234",
+			},
+		},
+		Source: "stdout",
+		Data:   "\ufeff{\"message\":\"with bom\",\"level\":\"INFO\"}\r",
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	msg, _ := createLogstashMessage(&m, &messageOptions{})
+	jq := makeQuery(msg)
+	assert.Equal("with bom", getString(jq, "message"))
+	assert.Equal("INFO", getString(jq, "event", "level"))
+
+	opts := &messageOptions{
+		json_prefix:      regexp.MustCompile(`^(?P<time>\S+) `),
+		timestamp_field:  "time",
+		timestamp_parser: newTimestampParser("rfc3339"),
+	}
+	m.Data = `2016-01-26T14:28:10Z {"message":"prefixed","level":"INFO"}`
+	msg, _ = createLogstashMessage(&m, opts)
+	jq = makeQuery(msg)
+	assert.Equal("prefixed", getString(jq, "message"))
+	assert.Equal("2016-01-26T14:28:10Z", getString(jq, "@timestamp"))
+	assert.Equal("INFO", getString(jq, "event", "level"))
+
+	m.Data = `[{"a":1},{"b":2}]`
+	msg, _ = createLogstashMessage(&m, &messageOptions{json_arrays: JSON_ARRAYS_FIELD, json_array_field: "items"})
+	jq = makeQuery(msg)
+	assert.Equal("no message", getString(jq, "message"))
+	assert.Equal(1, getInt(jq, "event", "items", "0", "a"))
+	assert.Equal(2, getInt(jq, "event", "items", "1", "b"))
+
+	// arrays are regular messages by default
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+	assert.Equal(`[{"a":1},{"b":2}]`, getString(jq, "message"))
+
+}
@@ -0,0 +1,42 @@
+package redis
+
+const (
+	K8S_LABEL_POD_NAME       = "io.kubernetes.pod.name"
+	K8S_LABEL_POD_NAMESPACE  = "io.kubernetes.pod.namespace"
+	K8S_LABEL_POD_UID        = "io.kubernetes.pod.uid"
+	K8S_LABEL_CONTAINER_NAME = "io.kubernetes.container.name"
+	K8S_LABEL_DOCKER_TYPE    = "io.kubernetes.docker.type"
+)
+
+type KubernetesFields struct {
+	Namespace string `json:"namespace,omitempty"`
+	Pod       string `json:"pod,omitempty"`
+	Container string `json:"container,omitempty"`
+	PodUID    string `json:"pod_uid,omitempty"`
+	Infra     bool   `json:"infra,omitempty"`
+}
+
+// kubernetesFields extracts pod metadata from the io.kubernetes.* labels the
+// kubelet puts on containers. Returns nil for containers not managed by
+// Kubernetes.
+func kubernetesFields(labels map[string]string) *KubernetesFields {
+	if labels[K8S_LABEL_POD_NAME] == "" {
+		return nil
+	}
+
+	return &KubernetesFields{
+		Namespace: labels[K8S_LABEL_POD_NAMESPACE],
+		Pod:       labels[K8S_LABEL_POD_NAME],
+		Container: labels[K8S_LABEL_CONTAINER_NAME],
+		PodUID:    labels[K8S_LABEL_POD_UID],
+		Infra:     isKubernetesInfraContainer(labels),
+	}
+}
+
+// isKubernetesInfraContainer returns true for the pause containers that hold a
+// pod's namespaces. Older kubelets name them 'POD', newer ones mark them with
+// the 'podsandbox' docker type.
+func isKubernetesInfraContainer(labels map[string]string) bool {
+	return labels[K8S_LABEL_DOCKER_TYPE] == "podsandbox" ||
+		(labels[K8S_LABEL_POD_NAME] != "" && labels[K8S_LABEL_CONTAINER_NAME] == "POD")
+}
@@ -0,0 +1,92 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKubernetesFields(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(kubernetesFields(nil))
+	assert.Nil(kubernetesFields(map[string]string{"com.example.team": "ops"}))
+
+	k8s := kubernetesFields(map[string]string{
+		"io.kubernetes.pod.name":       "web-5d8f7c9b4-x2x7k",
+		"io.kubernetes.pod.namespace":  "shop",
+		"io.kubernetes.pod.uid":        "0c4e5d0a-8c2e-11e6-9d3c-0800270c8a5b",
+		"io.kubernetes.container.name": "nginx",
+	})
+	assert.Equal(&KubernetesFields{
+		Namespace: "shop",
+		Pod:       "web-5d8f7c9b4-x2x7k",
+		Container: "nginx",
+		PodUID:    "0c4e5d0a-8c2e-11e6-9d3c-0800270c8a5b",
+	}, k8s)
+}
+
+func TestIsKubernetesInfraContainer(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.False(isKubernetesInfraContainer(nil))
+	assert.False(isKubernetesInfraContainer(map[string]string{"io.kubernetes.pod.name": "web", "io.kubernetes.container.name": "nginx"}))
+	assert.False(isKubernetesInfraContainer(map[string]string{"io.kubernetes.container.name": "POD"}))
+	assert.True(isKubernetesInfraContainer(map[string]string{"io.kubernetes.pod.name": "web", "io.kubernetes.container.name": "POD"}))
+	assert.True(isKubernetesInfraContainer(map[string]string{"io.kubernetes.pod.name": "web", "io.kubernetes.docker.type": "podsandbox"}))
+}
+
+func TestCreateLogstashMessageWithKubernetes(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/k8s_nginx_web-5d8f7c9b4-x2x7k_shop_0c4e5d0a_0",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "nginx:1.11",
+				Labels: map[string]string{
+					"io.kubernetes.pod.name":       "web-5d8f7c9b4-x2x7k",
+					"io.kubernetes.pod.namespace":  "shop",
+					"io.kubernetes.pod.uid":        "0c4e5d0a",
+					"io.kubernetes.container.name": "nginx",
+				},
+			},
+		},
+		Source: "stdout",
+		Data:   "hello world",
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	// kubernetes labels are read before the label filters are applied
+	opts := &messageOptions{kubernetes: true, exclude_labels: newGlobList("io.kubernetes.*")}
+	msg, _ := createLogstashMessage(&m, opts)
+	jq := makeQuery(msg)
+
+	assert.Equal("shop", getString(jq, "kubernetes", "namespace"))
+	assert.Equal("web-5d8f7c9b4-x2x7k", getString(jq, "kubernetes", "pod"))
+	assert.Equal("nginx", getString(jq, "kubernetes", "container"))
+	assert.Equal("0c4e5d0a", getString(jq, "kubernetes", "pod_uid"))
+	infra, _ := jq.Bool("kubernetes", "infra")
+	assert.False(infra)
+
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+	_, err := jq.Object("kubernetes")
+	assert.NotNil(err)
+
+	// dedotting the labels of the event leaves the container labels alone
+	msg, _ = createLogstashMessage(&m, &messageOptions{kubernetes: true, dedot_labels: true})
+	jq = makeQuery(msg)
+	assert.Equal("shop", getString(jq, "kubernetes", "namespace"))
+	assert.Equal("web-5d8f7c9b4-x2x7k", getString(jq, "docker", "labels", "io_kubernetes_pod_name"))
+	assert.Equal("web-5d8f7c9b4-x2x7k", m.Container.Config.Labels["io.kubernetes.pod.name"])
+	_, ok := m.Container.Config.Labels["io_kubernetes_pod_name"]
+	assert.False(ok)
+
+}
@@ -0,0 +1,146 @@
+package redis
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// globList is a list of shell-like patterns, where '*' matches any sequence of
+// characters (including '.' and '/') and '?' matches a single character.
+type globList []*regexp.Regexp
+
+func newGlobList(patterns string) globList {
+	var list globList
+	for _, p := range splitList(patterns) {
+		expr := regexp.QuoteMeta(p)
+		expr = strings.Replace(expr, `\*`, ".*", -1)
+		expr = strings.Replace(expr, `\?`, ".", -1)
+		list = append(list, regexp.MustCompile("^"+expr+"$"))
+	}
+	return list
+}
+
+func (g globList) Match(s string) bool {
+	for _, re := range g {
+		if re.MatchString(s) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitList splits a comma separated option value, dropping empty items.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// filterLabels returns a copy of labels with only the labels matching include
+// (all labels when include is empty) and not matching exclude. Afterwards the
+// first matching prefix is stripped from the label name. When labels end up
+// with the same name, the one with the longest original name wins, and of
+// those the first in sorted order.
+func filterLabels(labels map[string]string, include globList, exclude globList, strip_prefixes []string) map[string]string {
+	if labels == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	filtered := make(map[string]string, len(labels))
+	originals := make(map[string]string, len(labels))
+	for _, key := range keys {
+		original, value := key, labels[key]
+		if len(include) > 0 && !include.Match(key) {
+			continue
+		}
+		if exclude.Match(key) {
+			continue
+		}
+		for _, prefix := range strip_prefixes {
+			if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+				key = key[len(prefix):]
+				break
+			}
+		}
+		if other, ok := originals[key]; ok && len(other) >= len(original) {
+			continue
+		}
+		filtered[key] = value
+		originals[key] = original
+	}
+
+	return filtered
+}
+
+// nestLabels turns dotted label names into nested objects, so 'com.example.team'
+// becomes {"com":{"example":{"team":...}}}. When a label is both a leaf and a
+// parent (e.g. 'com.example' and 'com.example.team') the leaf value is moved
+// under the '_value' key of the object. Labels are processed in sorted order,
+// so an explicit 'com.example._value' label wins from a moved leaf.
+func nestLabels(labels map[string]string) map[string]interface{} {
+	if labels == nil {
+		return nil
+	}
+
+	keys := make([]string, 0, len(labels))
+	for key := range labels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	nested := make(map[string]interface{}, len(labels))
+	for _, key := range keys {
+		path := splitLabelPath(key)
+		if len(path) == 0 {
+			continue
+		}
+
+		node := nested
+		for _, segment := range path[:len(path)-1] {
+			switch child := node[segment].(type) {
+			case map[string]interface{}:
+				node = child
+			case string:
+				parent := map[string]interface{}{NESTED_LABEL_VALUE_KEY: child}
+				node[segment] = parent
+				node = parent
+			default:
+				parent := make(map[string]interface{})
+				node[segment] = parent
+				node = parent
+			}
+		}
+
+		leaf := path[len(path)-1]
+		if child, ok := node[leaf].(map[string]interface{}); ok {
+			child[NESTED_LABEL_VALUE_KEY] = labels[key]
+		} else {
+			node[leaf] = labels[key]
+		}
+	}
+
+	return nested
+}
+
+// splitLabelPath splits a label name on dots, ignoring empty segments.
+func splitLabelPath(key string) []string {
+	var path []string
+	for _, segment := range strings.Split(key, ".") {
+		if segment != "" {
+			path = append(path, segment)
+		}
+	}
+	return path
+}
@@ -0,0 +1,198 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGlobList(t *testing.T) {
+	assert := assert.New(t)
+
+	globs := newGlobList("io.kubernetes.*, com.docker.compose.?roject,app.kubernetes.io/*")
+	assert.True(globs.Match("io.kubernetes.pod.name"))
+	assert.True(globs.Match("com.docker.compose.project"))
+	assert.True(globs.Match("app.kubernetes.io/name"))
+	assert.False(globs.Match("com.docker.compose.service"))
+	assert.False(globs.Match("xio.kubernetes.pod.name"))
+
+	assert.False(newGlobList("").Match("anything"))
+}
+
+func TestFilterLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	labels := map[string]string{
+		"io.kubernetes.pod.name":     "web-1",
+		"com.docker.compose.service": "web",
+		"com.example.team":           "ops",
+		"com.example.tier":           "frontend",
+	}
+
+	filtered := filterLabels(labels, newGlobList("com.example.*"), nil, nil)
+	assert.Equal(map[string]string{"com.example.team": "ops", "com.example.tier": "frontend"}, filtered)
+
+	filtered = filterLabels(labels, nil, newGlobList("io.kubernetes.*,com.docker.*"), nil)
+	assert.Equal(map[string]string{"com.example.team": "ops", "com.example.tier": "frontend"}, filtered)
+
+	filtered = filterLabels(labels, newGlobList("com.*"), newGlobList("*.tier"), []string{"com.example.", "com."})
+	assert.Equal(map[string]string{"team": "ops", "docker.compose.service": "web"}, filtered)
+
+	// source labels are never modified
+	assert.Len(labels, 4)
+	assert.Nil(filterLabels(nil, newGlobList("*"), nil, nil))
+}
+
+func TestFilterLabelsStrippedConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	labels := map[string]string{
+		"a.b.x": "long",
+		"c.x":   "short",
+		"d.y":   "first",
+		"e.y":   "second",
+	}
+	// the longest original name wins, then the first in sorted order
+	for i := 0; i < 20; i++ {
+		filtered := filterLabels(labels, nil, nil, []string{"a.b.", "c.", "d.", "e."})
+		assert.Equal(map[string]string{"x": "long", "y": "first"}, filtered)
+	}
+}
+
+func TestCreateLogstashMessageWithLabelFilters(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "f00ffd9428dc",
+			Name: "/my_db",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:4321",
+				Labels: map[string]string{
+					"io.kubernetes.container.hash": "a1b2c3",
+					"com.example.team":             "ops",
+					"com.example.service.tier":     "db",
+				},
+			},
+		},
+		Source: "stderr",
+		Data:   "cruel world",
+		Time:   time.Unix(int64(1453813310), 1000000),
+	}
+
+	opts := &messageOptions{
+		exclude_labels:       newGlobList("io.kubernetes.*"),
+		strip_label_prefixes: []string{"com.example."},
+		dedot_labels:         true,
+	}
+	msg, _ := createLogstashMessage(&m, opts)
+	jq := makeQuery(msg)
+
+	assert.Equal("ops", getString(jq, "docker", "labels", "team"))
+	assert.Equal("db", getString(jq, "docker", "labels", "service_tier"))
+	assert.Equal("", getString(jq, "docker", "labels", "io_kubernetes_container_hash"))
+	assert.Len(m.Container.Config.Labels, 3)
+
+}
+
+func TestNestLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	nested := nestLabels(map[string]string{
+		"com.example.team": "ops",
+		"com.example.tier": "db",
+		"maintainer":       "me",
+	})
+	assert.Equal(map[string]interface{}{
+		"com": map[string]interface{}{
+			"example": map[string]interface{}{"team": "ops", "tier": "db"},
+		},
+		"maintainer": "me",
+	}, nested)
+
+	assert.Nil(nestLabels(nil))
+}
+
+func TestNestLabelsLeafAndParentConflict(t *testing.T) {
+	assert := assert.New(t)
+
+	// leaf before parent (in sorted order)
+	nested := nestLabels(map[string]string{
+		"com.example":      "leaf",
+		"com.example.team": "ops",
+	})
+	assert.Equal(map[string]interface{}{
+		"com": map[string]interface{}{
+			"example": map[string]interface{}{"_value": "leaf", "team": "ops"},
+		},
+	}, nested)
+
+	// deeper parent converts an existing leaf
+	nested = nestLabels(map[string]string{
+		"a":     "1",
+		"a.b":   "2",
+		"a.b.c": "3",
+	})
+	assert.Equal(map[string]interface{}{
+		"a": map[string]interface{}{
+			"_value": "1",
+			"b":      map[string]interface{}{"_value": "2", "c": "3"},
+		},
+	}, nested)
+
+	// an explicit _value label wins from a moved leaf
+	nested = nestLabels(map[string]string{
+		"a":        "moved",
+		"a._value": "explicit",
+		"a.b":      "2",
+	})
+	assert.Equal(map[string]interface{}{
+		"a": map[string]interface{}{"_value": "explicit", "b": "2"},
+	}, nested)
+}
+
+func TestNestLabelsEmptySegments(t *testing.T) {
+	assert := assert.New(t)
+
+	nested := nestLabels(map[string]string{
+		"a..b": "1",
+		".c.":  "2",
+		".":    "dropped",
+	})
+	assert.Equal(map[string]interface{}{
+		"a": map[string]interface{}{"b": "1"},
+		"c": "2",
+	}, nested)
+}
+
+func TestCreateLogstashMessageV1WithNestedLabels(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "f00ffd9428dc",
+			Name: "/my_db",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:4321",
+				Labels:   map[string]string{"com.example": "abc", "com.example.team": "def"},
+			},
+		},
+		Source: "stderr",
+		Data:   "cruel world",
+		Time:   time.Unix(int64(1453813310), 1000000),
+	}
+
+	msg, _ := createLogstashMessage(&m, &messageOptions{nest_labels: true})
+	jq := makeQuery(msg)
+
+	assert.Equal("abc", getString(jq, "docker", "labels", "com", "example", "_value"))
+	assert.Equal("def", getString(jq, "docker", "labels", "com", "example", "team"))
+
+}
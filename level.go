@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"strings"
+)
+
+const (
+	LEVEL_TRACE = "trace"
+	LEVEL_DEBUG = "debug"
+	LEVEL_INFO  = "info"
+	LEVEL_WARN  = "warn"
+	LEVEL_ERROR = "error"
+	LEVEL_FATAL = "fatal"
+)
+
+var levelAliases = map[string]string{
+	"trace":         LEVEL_TRACE,
+	"finest":        LEVEL_TRACE,
+	"finer":         LEVEL_TRACE,
+	"verbose":       LEVEL_TRACE,
+	"debug":         LEVEL_DEBUG,
+	"dbug":          LEVEL_DEBUG,
+	"fine":          LEVEL_DEBUG,
+	"info":          LEVEL_INFO,
+	"information":   LEVEL_INFO,
+	"informational": LEVEL_INFO,
+	"notice":        LEVEL_INFO,
+	"config":        LEVEL_INFO,
+	"warn":          LEVEL_WARN,
+	"warning":       LEVEL_WARN,
+	"error":         LEVEL_ERROR,
+	"err":           LEVEL_ERROR,
+	"eror":          LEVEL_ERROR,
+	"severe":        LEVEL_ERROR,
+	"fatal":         LEVEL_FATAL,
+	"crit":          LEVEL_FATAL,
+	"critical":      LEVEL_FATAL,
+	"alert":         LEVEL_FATAL,
+	"emerg":         LEVEL_FATAL,
+	"emergency":     LEVEL_FATAL,
+	"panic":         LEVEL_FATAL,
+}
+
+// normalizeLevel maps a level from embedded JSON onto one of the LEVEL_*
+// values. Besides common names it understands syslog severities (0-7) and
+// bunyan/pino numeric levels (10-60). Returns false for unknown levels.
+func normalizeLevel(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		level, ok := levelAliases[strings.ToLower(strings.TrimSpace(v))]
+		return level, ok
+	case float64:
+		switch {
+		case v >= 0 && v <= 2:
+			return LEVEL_FATAL, true
+		case v == 3:
+			return LEVEL_ERROR, true
+		case v == 4:
+			return LEVEL_WARN, true
+		case v == 5 || v == 6:
+			return LEVEL_INFO, true
+		case v == 7:
+			return LEVEL_DEBUG, true
+		case v >= 10 && v < 20:
+			return LEVEL_TRACE, true
+		case v >= 20 && v < 30:
+			return LEVEL_DEBUG, true
+		case v >= 30 && v < 40:
+			return LEVEL_INFO, true
+		case v >= 40 && v < 50:
+			return LEVEL_WARN, true
+		case v >= 50 && v < 60:
+			return LEVEL_ERROR, true
+		case v >= 60:
+			return LEVEL_FATAL, true
+		}
+	}
+	return "", false
+}
+
+// promoteLevel moves a known level from the embedded JSON fields to the
+// top-level level field.
+func (d *LogstashMessageV1) promoteLevel(field string) {
+	level, ok := normalizeLevel(d.LogtypeFields[field])
+	if !ok {
+		return
+	}
+	d.Level = level
+	delete(d.LogtypeFields, field)
+}
@@ -0,0 +1,50 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	for input, expected := range map[string]string{
+		"TRACE":    "trace",
+		"debug":    "debug",
+		" Info ":   "info",
+		"notice":   "info",
+		"WARNING":  "warn",
+		"err":      "error",
+		"SEVERE":   "error",
+		"critical": "fatal",
+		"panic":    "fatal",
+	} {
+		level, ok := normalizeLevel(input)
+		assert.True(ok, input)
+		assert.Equal(expected, level, input)
+	}
+
+	for input, expected := range map[float64]string{
+		0:  "fatal",
+		3:  "error",
+		4:  "warn",
+		6:  "info",
+		7:  "debug",
+		10: "trace",
+		20: "debug",
+		30: "info",
+		40: "warn",
+		50: "error",
+		60: "fatal",
+	} {
+		level, ok := normalizeLevel(input)
+		assert.True(ok)
+		assert.Equal(expected, level)
+	}
+
+	for _, input := range []interface{}{"loud", "", -1.0, 8.0, true, nil, map[string]interface{}{}} {
+		_, ok := normalizeLevel(input)
+		assert.False(ok)
+	}
+}
@@ -0,0 +1,79 @@
+package redis
+
+import (
+	"strconv"
+)
+
+const PARSE_LOGFMT = "logfmt"
+
+// parseLogfmt parses a logfmt line (e.g. 'level=info msg="started" port=8080')
+// into a field map. Values are kept as strings. To avoid treating plain text
+// as logfmt, false is returned unless every token is a key=value pair.
+func parseLogfmt(line string) (map[string]interface{}, bool) {
+	fields := make(map[string]interface{})
+
+	i := 0
+	for {
+		for i < len(line) && (line[i] == ' ' || line[i] == '\t') {
+			i++
+		}
+		if i == len(line) {
+			break
+		}
+
+		// key
+		start := i
+		for i < len(line) && line[i] > ' ' && line[i] != '=' && line[i] != '"' {
+			i++
+		}
+		if i == start || i == len(line) || line[i] != '=' {
+			return nil, false
+		}
+		key := line[start:i]
+		i++
+
+		// value
+		if i < len(line) && line[i] == '"' {
+			end := i + 1
+			for end < len(line) && line[end] != '"' {
+				if line[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(line) {
+				return nil, false
+			}
+			value, err := strconv.Unquote(line[i : end+1])
+			if err != nil {
+				return nil, false
+			}
+			fields[key] = value
+			i = end + 1
+			if i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				return nil, false
+			}
+		} else {
+			start = i
+			for i < len(line) && line[i] != ' ' && line[i] != '\t' {
+				if line[i] == '"' {
+					return nil, false
+				}
+				i++
+			}
+			fields[key] = line[start:i]
+		}
+	}
+
+	if len(fields) == 0 {
+		return nil, false
+	}
+
+	if _, ok := fields["message"]; !ok {
+		if msg, ok := fields["msg"]; ok {
+			fields["message"] = msg
+			delete(fields, "msg")
+		}
+	}
+	return fields, true
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	assert := assert.New(t)
+
+	fields, ok := parseLogfmt(`level=info msg="started server" port=8080 empty= path=/a=b`)
+	assert.True(ok)
+	assert.Equal(map[string]interface{}{
+		"level":   "info",
+		"message": "started server",
+		"port":    "8080",
+		"empty":   "",
+		"path":    "/a=b",
+	}, fields)
+
+	fields, ok = parseLogfmt("  err=\"quote \\\" and \\\\ backslash\"\tmessage=kept msg=too ")
+	assert.True(ok)
+	assert.Equal(map[string]interface{}{
+		"err":     `quote " and \ backslash`,
+		"message": "kept",
+		"msg":     "too",
+	}, fields)
+}
+
+func TestParseLogfmtRejectsPlainText(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, line := range []string{
+		"",
+		"   ",
+		"hello world",
+		"Listening on port=8080",
+		"GET /health 200",
+		"=value",
+		`key="unterminated`,
+		`key="value"trailing`,
+		`key=val"ue`,
+		"key",
+	} {
+		_, ok := parseLogfmt(line)
+		assert.False(ok, line)
+	}
+}
+
+func TestCreateLogstashMessageWithLogfmtData(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/my_app",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:1234",
+			},
+		},
+		Source: "stdout",
+		Data:   `logtype=applog level=info msg="started" port=8080`,
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	msg, _ := createLogstashMessage(&m, &messageOptions{parse: PARSE_LOGFMT})
+	jq := makeQuery(msg)
+
+	assert.Equal("started", getString(jq, "message"))
+	assert.Equal("applog", getString(jq, "logtype"))
+	assert.Equal("info", getString(jq, "applog", "level"))
+	assert.Equal("8080", getString(jq, "applog", "port"))
+
+	// not enabled
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+
+	assert.Equal(m.Data, getString(jq, "message"))
+	assert.Equal("", getString(jq, "logtype"))
+
+	// plain text
+	m.Data = "hello world"
+	msg, _ = createLogstashMessage(&m, &messageOptions{parse: PARSE_LOGFMT})
+	jq = makeQuery(msg)
+
+	assert.Equal("hello world", getString(jq, "message"))
+	_, err := jq.Object("event")
+	assert.NotNil(err)
+
+}
@@ -0,0 +1,186 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	LOG_DEBUG = iota
+	LOG_INFO
+	LOG_WARN
+	LOG_ERROR
+
+	LOG_FORMAT_TEXT = "text"
+	LOG_FORMAT_JSON = "json"
+
+	DEFAULT_LOG_RATE_INTERVAL = 10
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+// defaultLogger is used where no route is known, e.g. while parsing options.
+// It is configured with the LOG_LEVEL, DEBUG and LOG_FORMAT environment
+// variables.
+var defaultLogger = newDefaultLogger()
+
+// leveledLogger writes the diagnostics of the adapter, as text or as JSON
+// lines. Errors are rate limited per key: after an error is logged, errors
+// with the same key are counted but not logged for the interval. The next
+// error logged reports the number of similar errors suppressed.
+type leveledLogger struct {
+	level    int
+	format   string
+	route    string
+	interval time.Duration
+
+	mu     sync.Mutex
+	out    io.Writer
+	now    func() time.Time
+	limits map[string]*logLimit
+}
+
+type logLimit struct {
+	logged     time.Time
+	suppressed int
+}
+
+func newLeveledLogger(level string, format string, interval time.Duration, route string) (*leveledLogger, error) {
+	n, err := parseLogLevel(level)
+	if err != nil {
+		return nil, err
+	}
+	if format != LOG_FORMAT_TEXT && format != LOG_FORMAT_JSON {
+		return nil, fmt.Errorf("unknown log format '%s'", format)
+	}
+	return &leveledLogger{
+		level:    n,
+		format:   format,
+		route:    route,
+		interval: interval,
+		out:      os.Stderr,
+		now:      time.Now,
+		limits:   make(map[string]*logLimit),
+	}, nil
+}
+
+func newDefaultLogger() *leveledLogger {
+	l, err := newLeveledLogger(defaultLogLevel(nil), getopt(nil, "", "LOG_FORMAT", LOG_FORMAT_TEXT), 0, "")
+	if err != nil {
+		l, _ = newLeveledLogger("info", LOG_FORMAT_TEXT, 0, "")
+		l.Warnf("Invalid logging config: %v - using defaults", err)
+	}
+	return l
+}
+
+// defaultLogLevel returns the configured log level, where the debug option
+// enables debug logging.
+func defaultLogLevel(options map[string]string) string {
+	level := "info"
+	if getopt(options, "debug", "DEBUG", "") != "" {
+		level = "debug"
+	}
+	return getopt(options, "log_level", "LOG_LEVEL", level)
+}
+
+func parseLogLevel(level string) (int, error) {
+	for n, name := range logLevelNames {
+		if strings.EqualFold(level, name) {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level '%s'", level)
+}
+
+func (l *leveledLogger) Enabled(level int) bool {
+	return level >= l.level
+}
+
+func (l *leveledLogger) Debugf(format string, args ...interface{}) {
+	l.logf(LOG_DEBUG, 0, format, args...)
+}
+
+func (l *leveledLogger) Infof(format string, args ...interface{}) {
+	l.logf(LOG_INFO, 0, format, args...)
+}
+
+func (l *leveledLogger) Warnf(format string, args ...interface{}) {
+	l.logf(LOG_WARN, 0, format, args...)
+}
+
+// Errorf logs an error, unless an error with the same key was logged within
+// the interval.
+func (l *leveledLogger) Errorf(key string, format string, args ...interface{}) {
+	if !l.Enabled(LOG_ERROR) {
+		return
+	}
+	l.mu.Lock()
+	now := l.now()
+	limit := l.limits[key]
+	if limit == nil {
+		limit = &logLimit{}
+		l.limits[key] = limit
+	} else if now.Sub(limit.logged) < l.interval {
+		limit.suppressed++
+		l.mu.Unlock()
+		return
+	}
+	suppressed := limit.suppressed
+	limit.logged = now
+	limit.suppressed = 0
+	l.mu.Unlock()
+
+	l.logf(LOG_ERROR, suppressed, format, args...)
+}
+
+// Resolved logs the recovery from the errors with key, with the number of
+// errors suppressed since the last one logged. Nothing is logged if there
+// were no errors.
+func (l *leveledLogger) Resolved(key string, format string, args ...interface{}) {
+	l.mu.Lock()
+	limit := l.limits[key]
+	delete(l.limits, key)
+	l.mu.Unlock()
+
+	if limit != nil {
+		l.logf(LOG_INFO, limit.suppressed, format, args...)
+	}
+}
+
+func (l *leveledLogger) logf(level int, suppressed int, format string, args ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	now := l.now()
+	message := strings.TrimSuffix(fmt.Sprintf(format, args...), "\n")
+
+	var line []byte
+	if l.format == LOG_FORMAT_JSON {
+		entry := map[string]interface{}{
+			"time":    now.UTC().Format(time.RFC3339Nano),
+			"level":   logLevelNames[level],
+			"message": message,
+		}
+		if l.route != "" {
+			entry["route"] = l.route
+		}
+		if suppressed > 0 {
+			entry["suppressed"] = suppressed
+		}
+		line, _ = json.Marshal(entry)
+	} else {
+		if suppressed > 0 {
+			message = fmt.Sprintf("%s (%d similar errors suppressed)", message, suppressed)
+		}
+		line = []byte(fmt.Sprintf("%s %s %s", now.Format("2006/01/02 15:04:05"), strings.ToUpper(logLevelNames[level]), message))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(line, '\n'))
+}
@@ -0,0 +1,95 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testLogger(level string, format string, interval time.Duration) (*leveledLogger, *bytes.Buffer, *time.Time) {
+	l, _ := newLeveledLogger(level, format, interval, "r1")
+	out := &bytes.Buffer{}
+	now := time.Date(2016, 1, 26, 13, 1, 50, 0, time.UTC)
+	l.out = out
+	l.now = func() time.Time { return now }
+	return l, out, &now
+}
+
+func TestLoggerLevels(t *testing.T) {
+	assert := assert.New(t)
+
+	l, out, _ := testLogger("warn", LOG_FORMAT_TEXT, 0)
+	l.Debugf("debug %d", 1)
+	l.Infof("info %d", 2)
+	l.Warnf("warn %d\n", 3)
+	l.Errorf("key", "error %d", 4)
+	assert.Equal("2016/01/26 13:01:50 WARN warn 3\n2016/01/26 13:01:50 ERROR error 4\n", out.String())
+
+	_, err := newLeveledLogger("verbose", LOG_FORMAT_TEXT, 0, "")
+	assert.NotNil(err)
+	_, err = newLeveledLogger("info", "xml", 0, "")
+	assert.NotNil(err)
+	_, err = newLeveledLogger("DEBUG", LOG_FORMAT_JSON, 0, "")
+	assert.Nil(err)
+}
+
+func TestLoggerRateLimit(t *testing.T) {
+	assert := assert.New(t)
+
+	l, out, now := testLogger("info", LOG_FORMAT_TEXT, 10*time.Second)
+	for i := 0; i < 5; i++ {
+		l.Errorf("rpush", "error on rpush %d", i)
+		*now = now.Add(time.Second)
+	}
+	// other keys are not limited
+	l.Errorf("marshal", "error on marshal")
+	*now = now.Add(6 * time.Second)
+	l.Errorf("rpush", "error on rpush %d", 5)
+	l.Errorf("rpush", "error on rpush %d", 6)
+	l.Resolved("rpush", "rpush restored")
+	l.Resolved("rpush", "rpush restored again")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal([]string{
+		"2016/01/26 13:01:50 ERROR error on rpush 0",
+		"2016/01/26 13:01:55 ERROR error on marshal",
+		"2016/01/26 13:02:01 ERROR error on rpush 5 (4 similar errors suppressed)",
+		"2016/01/26 13:02:01 INFO rpush restored (1 similar errors suppressed)",
+	}, lines)
+}
+
+func TestLoggerJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	l, out, _ := testLogger("info", LOG_FORMAT_JSON, time.Minute)
+	l.Errorf("rpush", "error on rpush")
+	l.Errorf("rpush", "error on rpush")
+	l.Resolved("rpush", "rpush restored")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Len(lines, 2)
+	var entry map[string]interface{}
+	assert.Nil(json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(map[string]interface{}{
+		"time":    "2016-01-26T13:01:50Z",
+		"level":   "error",
+		"route":   "r1",
+		"message": "error on rpush",
+	}, entry)
+	entry = nil
+	assert.Nil(json.Unmarshal([]byte(lines[1]), &entry))
+	assert.Equal("info", entry["level"])
+	assert.Equal(1.0, entry["suppressed"])
+}
+
+func TestDefaultLogLevel(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("info", defaultLogLevel(map[string]string{}))
+	assert.Equal("debug", defaultLogLevel(map[string]string{"debug": "1"}))
+	assert.Equal("warn", defaultLogLevel(map[string]string{"debug": "1", "log_level": "warn"}))
+}
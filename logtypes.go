@@ -0,0 +1,82 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// reservedFieldNames are the top-level fields of the v1 layout, these cannot be
+// used as logtype.
+var reservedFieldNames = []string{
+	"@type", "@timestamp", "host", "message", "level", "docker", "kubernetes", "compose", "swarm", "logtype", "tags",
+	"truncated", "original_length", "dropped_fields", "chunk", "sample_rate",
+	"repeat_count", "first_timestamp", "last_timestamp", "event_id", "sequence", "adapter_sequence",
+}
+
+// logtypeRegistry holds the logtypes that get their own top-level field in the
+// v1 layout, and the name of the JSON input field used to select them.
+type logtypeRegistry struct {
+	field string
+	types map[string]bool
+}
+
+var defaultLogtypes, _ = newLogtypeRegistry(DEFAULT_LOGTYPE_FIELD, LOGTYPE_APPLICATIONLOG+","+LOGTYPE_ACCESSLOG)
+
+func newLogtypeRegistry(field string, logtypes string) (*logtypeRegistry, error) {
+	if field == "" {
+		return nil, fmt.Errorf("empty logtype field name")
+	}
+	registry := &logtypeRegistry{
+		field: field,
+		types: make(map[string]bool),
+	}
+	for _, logtype := range splitList(logtypes) {
+		if logtype == LOGTYPE_EVENT {
+			continue
+		}
+		for _, reserved := range reservedFieldNames {
+			if logtype == reserved {
+				return nil, fmt.Errorf("logtype '%s' clashes with the top-level field of that name", logtype)
+			}
+		}
+		registry.types[logtype] = true
+	}
+	return registry, nil
+}
+
+func (r *logtypeRegistry) Known(logtype string) bool {
+	return r.types[logtype]
+}
+
+// MarshalJSON adds the logtype fields under the name of the logtype (or 'event'
+// for input without a known logtype) to the regular struct fields.
+func (d LogstashMessageV1) MarshalJSON() ([]byte, error) {
+	type plain LogstashMessageV1
+	js, err := json.Marshal(plain(d))
+	if err != nil || len(d.LogtypeFields) == 0 {
+		return js, err
+	}
+
+	name := d.Logtype
+	if name == "" {
+		name = LOGTYPE_EVENT
+	}
+	key, err := json.Marshal(name)
+	if err != nil {
+		return nil, err
+	}
+	fields, err := json.Marshal(d.LogtypeFields)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(js[:len(js)-1])
+	buf.WriteByte(',')
+	buf.Write(key)
+	buf.WriteByte(':')
+	buf.Write(fields)
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,90 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLogtypeRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	registry, err := newLogtypeRegistry("logtype", "applog, accesslog,audit,,event")
+	assert.Nil(err)
+	assert.True(registry.Known("applog"))
+	assert.True(registry.Known("accesslog"))
+	assert.True(registry.Known("audit"))
+	assert.False(registry.Known("event"))
+	assert.False(registry.Known("metric"))
+
+	_, err = newLogtypeRegistry("logtype", "applog,docker")
+	assert.NotNil(err)
+
+	_, err = newLogtypeRegistry("", "applog")
+	assert.NotNil(err)
+
+	assert.True(defaultLogtypes.Known(LOGTYPE_APPLICATIONLOG))
+	assert.True(defaultLogtypes.Known(LOGTYPE_ACCESSLOG))
+}
+
+func TestLogstashMessageV1MarshalJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	msg := LogstashMessageV1{Message: "hi", Logtype: "audit", LogtypeFields: map[string]interface{}{"user": "bob"}}
+	js, err := msg.MarshalJSON()
+	assert.Nil(err)
+	jq := makeQuery(js)
+	assert.Equal("hi", getString(jq, "message"))
+	assert.Equal("audit", getString(jq, "logtype"))
+	assert.Equal("bob", getString(jq, "audit", "user"))
+
+	msg = LogstashMessageV1{Message: "hi", LogtypeFields: map[string]interface{}{"user": "bob"}}
+	js, _ = msg.MarshalJSON()
+	assert.Equal("bob", getString(makeQuery(js), "event", "user"))
+
+	// empty field maps are omitted
+	msg = LogstashMessageV1{Message: "hi", LogtypeFields: map[string]interface{}{}}
+	js, _ = msg.MarshalJSON()
+	_, err = makeQuery(js).Object("event")
+	assert.NotNil(err)
+}
+
+func TestCreateLogstashMessageWithCustomLogtypes(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/my_app",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:1234",
+			},
+		},
+		Source: "stdout",
+		Data:   `{"kind": "audit", "message":"user logged in", "user": "bob"}`,
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	logtypes, _ := newLogtypeRegistry("kind", "applog,audit,metric")
+	msg, _ := createLogstashMessage(&m, &messageOptions{logtypes: logtypes})
+	jq := makeQuery(msg)
+
+	assert.Equal("user logged in", getString(jq, "message"))
+	assert.Equal("audit", getString(jq, "logtype"))
+	assert.Equal("bob", getString(jq, "audit", "user"))
+	assert.Equal("", getString(jq, "audit", "kind"))
+
+	// the default registry does not know audit
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+
+	assert.Equal("", getString(jq, "logtype"))
+	assert.Equal("audit", getString(jq, "event", "kind"))
+	assert.Equal("bob", getString(jq, "event", "user"))
+
+}
@@ -0,0 +1,96 @@
+package redis
+
+import (
+	"encoding/json"
+	"regexp"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+// messageMatcher selects log lines on their source and container metadata, for
+// the filter, sample and grok rules. Conditions that are not set select all
+// log lines.
+type messageMatcher struct {
+	source      string
+	container   globList
+	image       globList
+	label_key   string
+	label_value globList
+}
+
+// Selects returns whether all conditions that are set match m. Images match
+// with and without tag.
+func (c *messageMatcher) Selects(m *router.Message) bool {
+	if c.source != "" && c.source != m.Source {
+		return false
+	}
+	if len(c.container) > 0 && !c.container.Match(strings.TrimPrefix(m.Container.Name, "/")) {
+		return false
+	}
+	if len(c.image) > 0 {
+		name, _ := splitImage(m.Container.Config.Image)
+		if !c.image.Match(m.Container.Config.Image) && !c.image.Match(name) {
+			return false
+		}
+	}
+	if c.label_key != "" {
+		value, ok := m.Container.Config.Labels[c.label_key]
+		if !ok || (len(c.label_value) > 0 && !c.label_value.Match(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// setLabel sets the label condition, in the form 'name' (the label is set) or
+// 'name=glob'.
+func (c *messageMatcher) setLabel(spec string) {
+	parts := strings.SplitN(spec, "=", 2)
+	c.label_key = parts[0]
+	if len(parts) == 2 {
+		c.label_value = newGlobList(parts[1])
+	}
+}
+
+// lazyFields returns a function returning the fields of the JSON object in a
+// log line. The log line is only parsed if a rule needs the fields, and at
+// most once.
+func lazyFields(data string, prefix *regexp.Regexp) func() map[string]interface{} {
+	var fields map[string]interface{}
+	parsed := false
+	return func() map[string]interface{} {
+		if !parsed {
+			fields = embeddedFields(data, prefix)
+			parsed = true
+		}
+		return fields
+	}
+}
+
+// embeddedFields returns the fields of the JSON object in a log line, or nil.
+func embeddedFields(data string, prefix *regexp.Regexp) map[string]interface{} {
+	js, _, ok := findJSON(data, prefix)
+	if !ok || !validJsonMessage(js) {
+		return nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(js), &fields); err != nil {
+		return nil
+	}
+	return fields
+}
+
+// lookupField returns the value at a dotted path (e.g. 'request.path') in
+// fields, or nil.
+func lookupField(fields map[string]interface{}, path string) interface{} {
+	var value interface{} = fields
+	for _, segment := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = object[segment]
+	}
+	return value
+}
@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageMatcher(t *testing.T) {
+	assert := assert.New(t)
+
+	m := testMessage("web_1", "stderr", "hi", map[string]string{"com.example.team": "ops"})
+	assert.True((&messageMatcher{}).Selects(m))
+	assert.True((&messageMatcher{source: "stderr", container: newGlobList("web_*"), image: newGlobList("app")}).Selects(m))
+	assert.True((&messageMatcher{image: newGlobList("app:1")}).Selects(m))
+	assert.False((&messageMatcher{source: "stdout"}).Selects(m))
+	assert.False((&messageMatcher{container: newGlobList("db_*")}).Selects(m))
+	assert.False((&messageMatcher{image: newGlobList("nginx")}).Selects(m))
+
+	matcher := &messageMatcher{}
+	matcher.setLabel("com.example.team=o*")
+	assert.True(matcher.Selects(m))
+	matcher.setLabel("com.example.team=dev")
+	assert.False(matcher.Selects(m))
+	matcher = &messageMatcher{}
+	matcher.setLabel("com.example.team")
+	assert.True(matcher.Selects(m))
+	matcher.setLabel("com.example.tier")
+	assert.False(matcher.Selects(m))
+}
+
+func TestLazyFields(t *testing.T) {
+	assert := assert.New(t)
+
+	fields := lazyFields(`{"a":1}`, nil)
+	assert.Equal(map[string]interface{}{"a": 1.0}, fields())
+	assert.Nil(lazyFields("plain", nil)())
+}
+
+func TestLookupField(t *testing.T) {
+	assert := assert.New(t)
+
+	fields := map[string]interface{}{"a": map[string]interface{}{"b": "c"}, "d": 1.0}
+	assert.Equal("c", lookupField(fields, "a.b"))
+	assert.Equal(1.0, lookupField(fields, "d"))
+	assert.Nil(lookupField(fields, "a.b.c"))
+	assert.Nil(lookupField(fields, "x"))
+	assert.Nil(lookupField(nil, "a"))
+}
@@ -0,0 +1,282 @@
+package redis
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	METRICS_PATH = "redis/metrics"
+
+	DROP_EXCLUDED    = "excluded"
+	DROP_INFRA       = "kubernetes_infra"
+	DROP_FILTERED    = "filtered"
+	DROP_SAMPLED     = "sampled"
+	DROP_RATE_LIMIT  = "rate_limited"
+	DROP_DEDUP       = "deduplicated"
+	DROP_PUSH_FAILED = "push_failed"
+)
+
+// pushLatencyBuckets are the upper bounds (in seconds) of the push latency
+// histogram.
+var pushLatencyBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+var dropReasons = []string{DROP_EXCLUDED, DROP_INFRA, DROP_FILTERED, DROP_SAMPLED, DROP_RATE_LIMIT, DROP_DEDUP, DROP_PUSH_FAILED}
+
+// adapterMetrics holds the metrics of a single route. Counters are updated by
+// Stream and read by the HTTP handler, so all access is atomic.
+type adapterMetrics struct {
+	route   string
+	address string
+
+	messages_in    int64
+	events_out     int64
+	bytes_out      int64
+	marshal_errors int64
+	push_errors    int64
+	retries        int64
+	reconnects     int64
+	dropped        map[string]*int64
+
+	latency_buckets []int64
+	latency_count   int64
+	latency_sum_ns  int64
+
+	mute_ns    int64
+	mute_since int64
+
+	queue func() int
+
+	// status, see status.go
+	started        time.Time
+	health_timeout time.Duration
+	pool           func() (active int, idle int)
+	last_push_ns   int64
+	status_mu      sync.Mutex
+	last_error     string
+	last_error_at  time.Time
+
+	// the route's rule counters, set after creation
+	filter   *messageFilter
+	redactor *redactor
+}
+
+// metricsRegistry holds the metrics of all redis routes.
+var metricsRegistry = struct {
+	sync.Mutex
+	adapters []*adapterMetrics
+}{}
+
+func newAdapterMetrics(route string, address string) *adapterMetrics {
+	m := &adapterMetrics{
+		route:           route,
+		address:         address,
+		dropped:         make(map[string]*int64, len(dropReasons)),
+		latency_buckets: make([]int64, len(pushLatencyBuckets)),
+		queue:           func() int { return 0 },
+		started:         time.Now(),
+		pool:            func() (int, int) { return 0, 0 },
+	}
+	for _, reason := range dropReasons {
+		m.dropped[reason] = new(int64)
+	}
+	return m
+}
+
+// registerMetrics adds m to the metrics exposed by the HTTP handler.
+func registerMetrics(m *adapterMetrics) {
+	metricsRegistry.Lock()
+	defer metricsRegistry.Unlock()
+	metricsRegistry.adapters = append(metricsRegistry.adapters, m)
+}
+
+// unregisterMetrics removes m from the metrics exposed by the HTTP handler.
+func unregisterMetrics(m *adapterMetrics) {
+	metricsRegistry.Lock()
+	defer metricsRegistry.Unlock()
+	for i, registered := range metricsRegistry.adapters {
+		if registered == m {
+			metricsRegistry.adapters = append(metricsRegistry.adapters[:i], metricsRegistry.adapters[i+1:]...)
+			return
+		}
+	}
+}
+
+func (m *adapterMetrics) Drop(reason string, n int) {
+	atomic.AddInt64(m.dropped[reason], int64(n))
+}
+
+func (m *adapterMetrics) Pushed(bytes int, latency time.Duration) {
+	atomic.StoreInt64(&m.last_push_ns, time.Now().UnixNano())
+	atomic.AddInt64(&m.events_out, 1)
+	atomic.AddInt64(&m.bytes_out, int64(bytes))
+	m.observeLatency(latency)
+}
+
+func (m *adapterMetrics) observeLatency(latency time.Duration) {
+	seconds := latency.Seconds()
+	for i, bound := range pushLatencyBuckets {
+		if seconds <= bound {
+			atomic.AddInt64(&m.latency_buckets[i], 1)
+			break
+		}
+	}
+	atomic.AddInt64(&m.latency_count, 1)
+	atomic.AddInt64(&m.latency_sum_ns, int64(latency))
+}
+
+// Mute records the start or end of mute mode.
+func (m *adapterMetrics) Mute(muted bool, now time.Time) {
+	if muted {
+		atomic.CompareAndSwapInt64(&m.mute_since, 0, now.UnixNano())
+		return
+	}
+	if since := atomic.SwapInt64(&m.mute_since, 0); since != 0 {
+		atomic.AddInt64(&m.mute_ns, now.UnixNano()-since)
+	}
+}
+
+// MuteSeconds returns the total time spent in mute mode, including the
+// current period.
+func (m *adapterMetrics) MuteSeconds(now time.Time) float64 {
+	ns := atomic.LoadInt64(&m.mute_ns)
+	if since := atomic.LoadInt64(&m.mute_since); since != 0 {
+		ns += now.UnixNano() - since
+	}
+	return time.Duration(ns).Seconds()
+}
+
+func metricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsRegistry.Lock()
+		adapters := append([]*adapterMetrics(nil), metricsRegistry.adapters...)
+		metricsRegistry.Unlock()
+
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		w.Write(formatMetrics(adapters, time.Now()))
+	})
+}
+
+// metricsWriter writes metrics in the Prometheus text format.
+type metricsWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *metricsWriter) Header(name string, typ string, help string) {
+	fmt.Fprintf(&w.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func (w *metricsWriter) Sample(name string, labels []string, value float64) {
+	w.buf.WriteString(name)
+	if len(labels) > 0 {
+		w.buf.WriteByte('{')
+		for i := 0; i < len(labels); i += 2 {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			fmt.Fprintf(&w.buf, "%s=\"%s\"", labels[i], escapeLabelValue(labels[i+1]))
+		}
+		w.buf.WriteByte('}')
+	}
+	w.buf.WriteByte(' ')
+	w.buf.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	w.buf.WriteByte('\n')
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatMetrics(adapters []*adapterMetrics, now time.Time) []byte {
+	w := &metricsWriter{}
+	labels := func(m *adapterMetrics, extra ...string) []string {
+		return append([]string{"route", m.route, "redis", m.address}, extra...)
+	}
+	counter := func(name string, help string, value func(m *adapterMetrics) int64) {
+		w.Header(name, "counter", help)
+		for _, m := range adapters {
+			w.Sample(name, labels(m), float64(value(m)))
+		}
+	}
+
+	counter("logspout_redis_messages_received_total", "Log lines received (after multiline assembly).",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.messages_in) })
+	counter("logspout_redis_events_pushed_total", "Events pushed to Redis.",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.events_out) })
+	counter("logspout_redis_bytes_pushed_total", "Bytes of events pushed to Redis.",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.bytes_out) })
+	counter("logspout_redis_marshal_errors_total", "Log lines that could not be turned into an event.",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.marshal_errors) })
+	counter("logspout_redis_push_errors_total", "Failed pushes to Redis, including retries.",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.push_errors) })
+	counter("logspout_redis_push_retries_total", "Pushes retried after an error.",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.retries) })
+	counter("logspout_redis_reconnects_total", "Reconnects to Redis after an error.",
+		func(m *adapterMetrics) int64 { return atomic.LoadInt64(&m.reconnects) })
+
+	w.Header("logspout_redis_dropped_total", "counter", "Log lines or events not shipped, by reason.")
+	for _, m := range adapters {
+		for _, reason := range dropReasons {
+			w.Sample("logspout_redis_dropped_total", labels(m, "reason", reason), float64(atomic.LoadInt64(m.dropped[reason])))
+		}
+	}
+
+	w.Header("logspout_redis_filter_hits_total", "counter", "Log lines matching a filter rule, by rule.")
+	for _, m := range adapters {
+		if m.filter != nil {
+			writeRuleCounts(w, "logspout_redis_filter_hits_total", labels(m), m.filter.Counts())
+		}
+	}
+	w.Header("logspout_redis_redactions_total", "counter", "Redacted values, by rule.")
+	for _, m := range adapters {
+		if m.redactor != nil {
+			writeRuleCounts(w, "logspout_redis_redactions_total", labels(m), m.redactor.Counts())
+		}
+	}
+
+	w.Header("logspout_redis_queue_length", "gauge", "Log lines held by the adapter, in multiline buffers and for deduplication.")
+	for _, m := range adapters {
+		w.Sample("logspout_redis_queue_length", labels(m), float64(m.queue()))
+	}
+
+	w.Header("logspout_redis_mute_seconds_total", "counter", "Time spent failing to push, from a failed push until the next successful one.")
+	for _, m := range adapters {
+		w.Sample("logspout_redis_mute_seconds_total", labels(m), m.MuteSeconds(now))
+	}
+
+	w.Header("logspout_redis_push_duration_seconds", "histogram", "Latency of pushes to Redis.")
+	for _, m := range adapters {
+		cumulative := int64(0)
+		for i, bound := range pushLatencyBuckets {
+			cumulative += atomic.LoadInt64(&m.latency_buckets[i])
+			le := strconv.FormatFloat(bound, 'g', -1, 64)
+			w.Sample("logspout_redis_push_duration_seconds_bucket", labels(m, "le", le), float64(cumulative))
+		}
+		count := atomic.LoadInt64(&m.latency_count)
+		w.Sample("logspout_redis_push_duration_seconds_bucket", labels(m, "le", "+Inf"), float64(count))
+		w.Sample("logspout_redis_push_duration_seconds_sum", labels(m), time.Duration(atomic.LoadInt64(&m.latency_sum_ns)).Seconds())
+		w.Sample("logspout_redis_push_duration_seconds_count", labels(m), float64(count))
+	}
+
+	return w.buf.Bytes()
+}
+
+func writeRuleCounts(w *metricsWriter, name string, labels []string, counts map[string]int64) {
+	rules := make([]string, 0, len(counts))
+	for rule := range counts {
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	for _, rule := range rules {
+		w.Sample(name, append(append([]string(nil), labels...), "rule", rule), float64(counts[rule]))
+	}
+}
@@ -0,0 +1,85 @@
+package redis
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFormatMetrics(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newAdapterMetrics("r1", "redis:6379")
+	m.messages_in = 10
+	m.Pushed(100, 2*time.Millisecond)
+	m.Pushed(50, 2*time.Second)
+	m.Drop(DROP_FILTERED, 3)
+	m.queue = func() int { return 7 }
+	m.filter, _ = newMessageFilter("drop message=health", "", FILTER_KEEP, nil)
+
+	metrics := string(formatMetrics([]*adapterMetrics{m}, time.Now()))
+
+	for _, line := range []string{
+		"# TYPE logspout_redis_messages_received_total counter",
+		`logspout_redis_messages_received_total{route="r1",redis="redis:6379"} 10`,
+		`logspout_redis_events_pushed_total{route="r1",redis="redis:6379"} 2`,
+		`logspout_redis_bytes_pushed_total{route="r1",redis="redis:6379"} 150`,
+		`logspout_redis_push_errors_total{route="r1",redis="redis:6379"} 0`,
+		`logspout_redis_dropped_total{route="r1",redis="redis:6379",reason="filtered"} 3`,
+		`logspout_redis_dropped_total{route="r1",redis="redis:6379",reason="sampled"} 0`,
+		`logspout_redis_filter_hits_total{route="r1",redis="redis:6379",rule="option1"} 0`,
+		`logspout_redis_queue_length{route="r1",redis="redis:6379"} 7`,
+		"# TYPE logspout_redis_push_duration_seconds histogram",
+		`logspout_redis_push_duration_seconds_bucket{route="r1",redis="redis:6379",le="0.001"} 0`,
+		`logspout_redis_push_duration_seconds_bucket{route="r1",redis="redis:6379",le="0.0025"} 1`,
+		`logspout_redis_push_duration_seconds_bucket{route="r1",redis="redis:6379",le="1"} 1`,
+		`logspout_redis_push_duration_seconds_bucket{route="r1",redis="redis:6379",le="+Inf"} 2`,
+		`logspout_redis_push_duration_seconds_sum{route="r1",redis="redis:6379"} 2.002`,
+		`logspout_redis_push_duration_seconds_count{route="r1",redis="redis:6379"} 2`,
+	} {
+		assert.Contains(metrics, line+"\n")
+	}
+}
+
+func TestMetricsMuteSeconds(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	m := newAdapterMetrics("r1", "redis:6379")
+	assert.Equal(0.0, m.MuteSeconds(now))
+
+	m.Mute(true, now)
+	// muting again keeps the start
+	m.Mute(true, now.Add(time.Second))
+	assert.Equal(2.0, m.MuteSeconds(now.Add(2*time.Second)))
+	m.Mute(false, now.Add(3*time.Second))
+	m.Mute(false, now.Add(4*time.Second))
+	assert.Equal(3.0, m.MuteSeconds(now.Add(10*time.Second)))
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(`a\\b\"c\nd`, escapeLabelValue("a\\b\"c\nd"))
+}
+
+func TestMetricsHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newAdapterMetrics("handler-route", "redis:6379")
+	registerMetrics(m)
+
+	w := httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/"+METRICS_PATH, nil))
+	assert.Equal(200, w.Code)
+	assert.True(strings.HasPrefix(w.Header().Get("Content-Type"), "text/plain"))
+	assert.Contains(w.Body.String(), `route="handler-route"`)
+
+	unregisterMetrics(m)
+	w = httptest.NewRecorder()
+	metricsHandler().ServeHTTP(w, httptest.NewRequest("GET", "/"+METRICS_PATH, nil))
+	assert.NotContains(w.Body.String(), `route="handler-route"`)
+}
@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	DEFAULT_MULTILINE_MAX_LINES = 500
+	DEFAULT_MULTILINE_MAX_BYTES = 65536
+	DEFAULT_MULTILINE_TIMEOUT   = 500
+)
+
+// multilineAggregator joins consecutive log lines (e.g. stack traces) of a
+// container into a single message. Lines are buffered per container and
+// source, so stdout and stderr lines are never mixed.
+//
+// An event either starts with a line matching start, or every line matching
+// cont is appended to the event before it. Events are flushed when the next
+// event starts, when max_lines or max_bytes is reached, or when no line was
+// added for the timeout.
+//
+// If overrides is set, the start pattern can be set per container with a label.
+// Lines of containers without a pattern are passed on as is.
+type multilineAggregator struct {
+	start     *regexp.Regexp
+	cont      *regexp.Regexp
+	max_lines int
+	max_bytes int
+	timeout   time.Duration
+	buffers   map[string]*multilineBuffer
+	overrides *overrideCache
+	// number of buffered lines, read by the metrics handler
+	buffered int64
+}
+
+type multilineBuffer struct {
+	first   *router.Message
+	lines   []string
+	bytes   int
+	updated time.Time
+}
+
+func newMultilineAggregator(start *regexp.Regexp, cont *regexp.Regexp, max_lines int, max_bytes int, timeout time.Duration) *multilineAggregator {
+	return &multilineAggregator{
+		start:     start,
+		cont:      cont,
+		max_lines: max_lines,
+		max_bytes: max_bytes,
+		timeout:   timeout,
+		buffers:   make(map[string]*multilineBuffer),
+	}
+}
+
+// Process reads messages from in and returns a channel with the aggregated
+// messages. The returned channel is closed after in is closed and all buffered
+// lines are flushed.
+func (ml *multilineAggregator) Process(in chan *router.Message) chan *router.Message {
+	out := make(chan *router.Message)
+
+	go func() {
+		defer close(out)
+
+		tick := ml.timeout / 2
+		if tick < 10*time.Millisecond {
+			tick = 10 * time.Millisecond
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case m, ok := <-in:
+				if !ok {
+					for key := range ml.buffers {
+						ml.flush(key, out)
+					}
+					return
+				}
+				ml.add(m, time.Now(), out)
+			case now := <-ticker.C:
+				for key, buf := range ml.buffers {
+					if now.Sub(buf.updated) >= ml.timeout {
+						ml.flush(key, out)
+					}
+				}
+			}
+		}
+	}()
+
+	return out
+}
+
+func (ml *multilineAggregator) add(m *router.Message, now time.Time, out chan *router.Message) {
+	key := m.Container.ID + "/" + m.Source
+	buf := ml.buffers[key]
+
+	start, cont := ml.start, ml.cont
+	if ml.overrides != nil {
+		if re := ml.overrides.Get(m).multiline; re != nil {
+			start, cont = re, nil
+		}
+	}
+	if start == nil && cont == nil {
+		ml.flush(key, out)
+		out <- m
+		return
+	}
+
+	if buf != nil && !continues(start, cont, m.Data) {
+		ml.flush(key, out)
+		buf = nil
+	}
+	if buf != nil && buf.bytes+1+len(m.Data) > ml.max_bytes {
+		ml.flush(key, out)
+		buf = nil
+	}
+
+	if buf == nil {
+		buf = &multilineBuffer{first: m}
+		ml.buffers[key] = buf
+	} else {
+		buf.bytes++
+	}
+	buf.lines = append(buf.lines, m.Data)
+	atomic.AddInt64(&ml.buffered, 1)
+	buf.bytes += len(m.Data)
+	buf.updated = now
+
+	if len(buf.lines) >= ml.max_lines || buf.bytes >= ml.max_bytes {
+		ml.flush(key, out)
+	}
+}
+
+// continues returns true if line is part of the event buffered before it.
+func continues(start *regexp.Regexp, cont *regexp.Regexp, line string) bool {
+	if start != nil {
+		return !start.MatchString(line)
+	}
+	return cont.MatchString(line)
+}
+
+// Buffered returns the number of lines waiting for the rest of their event.
+// Unlike the other methods, it can be called from any goroutine.
+func (ml *multilineAggregator) Buffered() int {
+	return int(atomic.LoadInt64(&ml.buffered))
+}
+
+func (ml *multilineAggregator) flush(key string, out chan *router.Message) {
+	buf := ml.buffers[key]
+	delete(ml.buffers, key)
+	if buf == nil {
+		return
+	}
+	atomic.AddInt64(&ml.buffered, -int64(len(buf.lines)))
+
+	m := *buf.first
+	m.Data = strings.Join(buf.lines, "\n")
+	out <- &m
+}
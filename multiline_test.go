@@ -0,0 +1,153 @@
+package redis
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+// runMultiline feeds all messages through the aggregator and returns the data
+// of the aggregated messages.
+func runMultiline(ml *multilineAggregator, messages ...*router.Message) []string {
+	in := make(chan *router.Message)
+	out := ml.Process(in)
+	go func() {
+		for _, m := range messages {
+			in <- m
+		}
+		close(in)
+	}()
+
+	var result []string
+	for m := range out {
+		result = append(result, m.Data)
+	}
+	return result
+}
+
+func TestMultilineStartPattern(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(regexp.MustCompile(`^\d{4}-`), nil, 500, 65536, time.Second)
+	result := runMultiline(ml,
+		testMessage("a", "stdout", "2016-10-16 ERROR boom", nil),
+		testMessage("a", "stdout", "java.lang.RuntimeException: boom", nil),
+		testMessage("a", "stdout", "\tat com.example.Main.main(Main.java:3)", nil),
+		testMessage("a", "stdout", "2016-10-16 INFO next", nil),
+	)
+
+	assert.Equal([]string{
+		"2016-10-16 ERROR boom\njava.lang.RuntimeException: boom\n\tat com.example.Main.main(Main.java:3)",
+		"2016-10-16 INFO next",
+	}, result)
+}
+
+func TestMultilineContinuePattern(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(nil, regexp.MustCompile(`^(\s|Caused by:)`), 500, 65536, time.Second)
+	result := runMultiline(ml,
+		testMessage("a", "stderr", "Traceback (most recent call last):", nil),
+		testMessage("a", "stderr", `  File "app.py", line 1, in <module>`, nil),
+		testMessage("a", "stderr", "ValueError: boom", nil),
+		testMessage("a", "stderr", "next", nil),
+	)
+
+	assert.Equal([]string{
+		"Traceback (most recent call last):\n  File \"app.py\", line 1, in <module>",
+		"ValueError: boom",
+		"next",
+	}, result)
+}
+
+func TestMultilineNeverMixesContainersOrSources(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(nil, regexp.MustCompile(`^\s`), 500, 65536, time.Second)
+
+	in := make(chan *router.Message)
+	out := ml.Process(in)
+	go func() {
+		in <- testMessage("a", "stdout", "a-out", nil)
+		in <- testMessage("b", "stdout", "b-out", nil)
+		in <- testMessage("a", "stderr", "a-err", nil)
+		in <- testMessage("a", "stdout", " a-out-2", nil)
+		in <- testMessage("b", "stdout", " b-out-2", nil)
+		in <- testMessage("a", "stderr", " a-err-2", nil)
+		close(in)
+	}()
+
+	result := map[string]string{}
+	for m := range out {
+		result[m.Container.ID+"/"+m.Source] = m.Data
+	}
+
+	assert.Equal(map[string]string{
+		"a/stdout": "a-out\n a-out-2",
+		"a/stderr": "a-err\n a-err-2",
+		"b/stdout": "b-out\n b-out-2",
+	}, result)
+}
+
+func TestMultilineMaxLinesAndBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(nil, regexp.MustCompile(`^\s`), 2, 65536, time.Second)
+	result := runMultiline(ml,
+		testMessage("a", "stdout", "1", nil),
+		testMessage("a", "stdout", " 2", nil),
+		testMessage("a", "stdout", " 3", nil),
+	)
+	assert.Equal([]string{"1\n 2", " 3"}, result)
+
+	ml = newMultilineAggregator(nil, regexp.MustCompile(`^\s`), 500, 8, time.Second)
+	result = runMultiline(ml,
+		testMessage("a", "stdout", "123", nil),
+		testMessage("a", "stdout", " 56", nil),
+		testMessage("a", "stdout", " 89", nil),
+		testMessage("a", "stdout", " 123456789", nil),
+	)
+	assert.Equal([]string{"123\n 56", " 89", " 123456789"}, result)
+}
+
+func TestMultilineTimeout(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(nil, regexp.MustCompile(`^\s`), 500, 65536, 20*time.Millisecond)
+
+	in := make(chan *router.Message)
+	out := ml.Process(in)
+	in <- testMessage("a", "stdout", "first", nil)
+	in <- testMessage("a", "stdout", " second", nil)
+
+	select {
+	case m := <-out:
+		assert.Equal("first\n second", m.Data)
+		assert.Equal(0, ml.Buffered())
+	case <-time.After(time.Second):
+		t.Fatal("multiline event not flushed after timeout")
+	}
+
+	close(in)
+	_, ok := <-out
+	assert.False(ok)
+}
+
+func TestMultilineBuffered(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(nil, regexp.MustCompile(`^\s`), 500, 65536, time.Second)
+	out := make(chan *router.Message, 10)
+	now := time.Now()
+	ml.add(testMessage("a", "stdout", "first", nil), now, out)
+	ml.add(testMessage("a", "stdout", " second", nil), now, out)
+	ml.add(testMessage("b", "stdout", "other", nil), now, out)
+	assert.Equal(3, ml.Buffered())
+
+	ml.add(testMessage("a", "stdout", "next", nil), now, out)
+	assert.Equal(2, ml.Buffered())
+	assert.Len(out, 1)
+}
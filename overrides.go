@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	LABEL_KEY       = "logspout.redis.key"
+	LABEL_LOGTYPE   = "logspout.redis.logtype"
+	LABEL_LAYOUT    = "logspout.redis.layout"
+	LABEL_PARSE     = "logspout.redis.parse"
+	LABEL_MULTILINE = "logspout.redis.multiline"
+	LABEL_EXCLUDE   = "logspout.redis.exclude"
+
+	LAYOUT_V0  = "v0"
+	LAYOUT_V1  = "v1"
+	PARSE_NONE = "none"
+
+	MAX_CACHED_OVERRIDES = 1000
+)
+
+// containerOverrides are the route settings for a container, after applying
+// the logspout.redis.* labels of the container.
+type containerOverrides struct {
+	exclude   bool
+	key       string
+	opts      *messageOptions
+	multiline *regexp.Regexp
+}
+
+// overrideCache parses the labels of every container once. It is used by the
+// multiline stage and by Stream, so access is locked.
+type overrideCache struct {
+	mu         sync.Mutex
+	key        string
+	opts       *messageOptions
+	containers map[string]*containerOverrides
+	log        *leveledLogger
+}
+
+func newOverrideCache(key string, opts *messageOptions) *overrideCache {
+	return &overrideCache{
+		key:        key,
+		opts:       opts,
+		containers: make(map[string]*containerOverrides),
+		log:        defaultLogger,
+	}
+}
+
+func (c *overrideCache) Get(m *router.Message) *containerOverrides {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	overrides, ok := c.containers[m.Container.ID]
+	if !ok {
+		// removed containers are never forgotten otherwise
+		if len(c.containers) >= MAX_CACHED_OVERRIDES {
+			c.containers = make(map[string]*containerOverrides)
+		}
+		overrides = parseOverrides(m, c.key, c.opts, c.log)
+		c.containers[m.Container.ID] = overrides
+	}
+	return overrides
+}
+
+// parseOverrides applies the logspout.redis.* labels of the container of m to
+// the route settings. Invalid labels are logged and ignored.
+func parseOverrides(m *router.Message, key string, opts *messageOptions, logger *leveledLogger) *containerOverrides {
+	labels := m.Container.Config.Labels
+	overrides := &containerOverrides{key: key, opts: opts}
+	if len(labels) == 0 {
+		return overrides
+	}
+
+	invalid := func(label string) {
+		logger.Warnf("redis: ignoring invalid label %s=%q of container %s\n", label, labels[label], shortID(m.Container.ID))
+	}
+	// opts are copied on first change only
+	var copied *messageOptions
+	modify := func() *messageOptions {
+		if copied == nil {
+			o := *opts
+			copied = &o
+			overrides.opts = copied
+		}
+		return copied
+	}
+
+	if value, ok := labels[LABEL_EXCLUDE]; ok {
+		overrides.exclude = strings.TrimSpace(value) == "true"
+	}
+	if value := strings.TrimSpace(labels[LABEL_KEY]); value != "" {
+		overrides.key = value
+	}
+	if value, ok := labels[LABEL_LOGTYPE]; ok {
+		logtypes := opts.logtypes
+		if logtypes == nil {
+			logtypes = defaultLogtypes
+		}
+		if logtypes.Known(value) {
+			modify().logtype = value
+		} else {
+			invalid(LABEL_LOGTYPE)
+		}
+	}
+	if value, ok := labels[LABEL_LAYOUT]; ok {
+		switch value {
+		case LAYOUT_V0:
+			modify().use_v0 = true
+		case LAYOUT_V1:
+			modify().use_v0 = false
+		default:
+			invalid(LABEL_LAYOUT)
+		}
+	}
+	if value, ok := labels[LABEL_PARSE]; ok {
+		switch value {
+		case PARSE_LOGFMT:
+			modify().parse = PARSE_LOGFMT
+		case PARSE_NONE:
+			modify().parse = ""
+		default:
+			invalid(LABEL_PARSE)
+		}
+	}
+	if value, ok := labels[LABEL_MULTILINE]; ok {
+		if re, err := regexp.Compile(value); err == nil && value != "" {
+			overrides.multiline = re
+		} else {
+			invalid(LABEL_MULTILINE)
+		}
+	}
+	return overrides
+}
@@ -0,0 +1,124 @@
+package redis
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOverrides(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := &messageOptions{parse: PARSE_LOGFMT}
+
+	overrides := parseOverrides(testMessage("a", "stdout", "level=info msg=hello", nil), "logspout", opts, defaultLogger)
+	assert.Equal("logspout", overrides.key)
+	assert.True(overrides.opts == opts)
+	assert.False(overrides.exclude)
+	assert.Nil(overrides.multiline)
+
+	overrides = parseOverrides(testMessage("a", "stdout", "level=info msg=hello", map[string]string{
+		LABEL_KEY:       "team-a",
+		LABEL_LOGTYPE:   LOGTYPE_ACCESSLOG,
+		LABEL_LAYOUT:    LAYOUT_V0,
+		LABEL_PARSE:     PARSE_NONE,
+		LABEL_MULTILINE: `^\d{4}-`,
+		LABEL_EXCLUDE:   "false",
+	}), "logspout", opts, defaultLogger)
+	assert.Equal("team-a", overrides.key)
+	assert.Equal(LOGTYPE_ACCESSLOG, overrides.opts.logtype)
+	assert.True(overrides.opts.use_v0)
+	assert.Equal("", overrides.opts.parse)
+	assert.Equal(`^\d{4}-`, overrides.multiline.String())
+	assert.False(overrides.exclude)
+	// route options are never modified
+	assert.Equal(PARSE_LOGFMT, opts.parse)
+	assert.False(opts.use_v0)
+
+	overrides = parseOverrides(testMessage("a", "stdout", "level=info msg=hello", map[string]string{LABEL_EXCLUDE: "true"}), "logspout", opts, defaultLogger)
+	assert.True(overrides.exclude)
+}
+
+func TestParseOverridesIgnoresInvalidLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	opts := &messageOptions{parse: PARSE_LOGFMT}
+	overrides := parseOverrides(testMessage("a", "stdout", "level=info msg=hello", map[string]string{
+		LABEL_KEY:       " ",
+		LABEL_LOGTYPE:   "docker",
+		LABEL_LAYOUT:    "v2",
+		LABEL_PARSE:     "xml",
+		LABEL_MULTILINE: "(",
+	}), "logspout", opts, defaultLogger)
+	assert.Equal("logspout", overrides.key)
+	assert.True(overrides.opts == opts)
+	assert.Nil(overrides.multiline)
+}
+
+func TestOverrideCache(t *testing.T) {
+	assert := assert.New(t)
+
+	c := newOverrideCache("logspout", &messageOptions{})
+	m := testMessage("a", "stdout", "level=info msg=hello", map[string]string{LABEL_KEY: "team-a"})
+	overrides := c.Get(m)
+	assert.Equal("team-a", overrides.key)
+
+	// labels are parsed once per container
+	m.Container.Config.Labels[LABEL_KEY] = "team-b"
+	assert.True(overrides == c.Get(m))
+
+	for i := 0; i < MAX_CACHED_OVERRIDES; i++ {
+		c.Get(testMessage(fmt.Sprintf("c%d", i), "stdout", "level=info msg=hello", nil))
+	}
+	assert.True(len(c.containers) <= MAX_CACHED_OVERRIDES)
+}
+
+func TestCreateLogstashMessageWithLogtypeOverride(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := testMessage("a", "stdout", "level=info msg=hello", nil)
+	msg, _ := createLogstashMessage(m, &messageOptions{parse: PARSE_LOGFMT, logtype: LOGTYPE_ACCESSLOG})
+	jq := makeQuery(msg)
+	assert.Equal(LOGTYPE_ACCESSLOG, getString(jq, "logtype"))
+	assert.Equal("info", getString(jq, LOGTYPE_ACCESSLOG, "level"))
+
+	// a logtype from the log line wins
+	m.Data = `{"logtype":"applog","message":"hi"}`
+	msg, _ = createLogstashMessage(m, &messageOptions{logtype: LOGTYPE_ACCESSLOG})
+	assert.Equal(LOGTYPE_APPLICATIONLOG, getString(makeQuery(msg), "logtype"))
+
+}
+
+func TestMultilineOverride(t *testing.T) {
+	assert := assert.New(t)
+
+	ml := newMultilineAggregator(nil, nil, 500, 65536, time.Second)
+	ml.overrides = newOverrideCache("logspout", &messageOptions{})
+
+	java := map[string]string{LABEL_MULTILINE: `^\d{4}-`}
+	result := runMultiline(ml,
+		testMessage("plain", "stdout", "line 1", nil),
+		testMessage("plain", "stdout", "  line 2", nil),
+	)
+	assert.Equal([]string{"line 1", "  line 2"}, result)
+
+	a := testMessage("java", "stdout", "2016-10-16 ERROR boom", nil)
+	a.Container.Config.Labels = java
+	b := testMessage("java", "stdout", "\tat Main.main", nil)
+	b.Container.Config.Labels = java
+	result = runMultiline(ml, a, b)
+	assert.Equal([]string{"2016-10-16 ERROR boom\n\tat Main.main"}, result)
+
+	// a label overrides the route pattern
+	ml = newMultilineAggregator(nil, regexp.MustCompile(`^\s`), 500, 65536, time.Second)
+	ml.overrides = newOverrideCache("logspout", &messageOptions{})
+	a = testMessage("java", "stdout", "2016-10-16 ERROR boom", nil)
+	a.Container.Config.Labels = java
+	b = testMessage("java", "stdout", "Caused by: x", nil)
+	b.Container.Config.Labels = java
+	assert.Equal([]string{"2016-10-16 ERROR boom\nCaused by: x"}, runMultiline(ml, a, b))
+}
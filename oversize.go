@@ -0,0 +1,158 @@
+package redis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	OVERSIZE_TRUNCATE = "truncate"
+	OVERSIZE_SPLIT    = "split"
+)
+
+type ChunkFields struct {
+	ID    string `json:"id"`
+	Index int    `json:"index"`
+	Count int    `json:"count"`
+}
+
+// createLogstashMessages works like createLogstashMessage, but with the split
+// policy, a message larger than max_message_bytes is shipped as a number of
+// chunk events sharing a correlation id.
+func createLogstashMessages(m *router.Message, opts *messageOptions) ([][]byte, error) {
+	data, invalid_utf8 := m.Data, false
+	if opts.oversize == OVERSIZE_SPLIT && opts.max_message_bytes > 0 {
+		// repair before measuring, as an invalid byte takes three bytes repaired
+		data, invalid_utf8 = repairUTF8(m.Data)
+	}
+	if opts.oversize != OVERSIZE_SPLIT || opts.max_message_bytes <= 0 || len(data) <= opts.max_message_bytes {
+		js, err := createLogstashMessage(m, opts)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{js}, nil
+	}
+
+	// the chunks are repaired already, so tag them here
+	split := *opts
+	opts = &split
+	if invalid_utf8 {
+		opts.tags = append(append([]string(nil), opts.tags...), TAG_INVALID_UTF8)
+	}
+	// redact before splitting, so a value spanning chunks is not missed
+	if opts.redactor != nil {
+		data = opts.redactor.Redact(data)
+		opts.redactor = nil
+	}
+	chunks := splitUTF8(data, opts.max_message_bytes)
+	id := newCorrelationID()
+	events := make([][]byte, 0, len(chunks))
+	for i, chunk := range chunks {
+		c := *m
+		c.Data = chunk
+		js, err := buildLogstashMessage(&c, opts, &ChunkFields{ID: id, Index: i + 1, Count: len(chunks)})
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, js)
+	}
+	return events, nil
+}
+
+// truncateUTF8 cuts s to at most max bytes, without splitting a UTF-8 sequence.
+func truncateUTF8(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	n := max
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// splitUTF8 splits s in chunks of at most max bytes, without splitting a UTF-8
+// sequence.
+func splitUTF8(s string, max int) []string {
+	var chunks []string
+	for len(s) > 0 {
+		chunk := truncateUTF8(s, max)
+		if chunk == "" {
+			// max is smaller than a single rune
+			_, size := utf8.DecodeRuneInString(s)
+			chunk = s[:size]
+		}
+		chunks = append(chunks, chunk)
+		s = s[len(chunk):]
+	}
+	return chunks
+}
+
+func newCorrelationID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// capFields limits the fields of embedded JSON to max_fields (counted over all
+// levels, 0 is unlimited). Objects and arrays nested deeper than max_depth (0
+// is unlimited) are replaced by their JSON string. Fields are visited in
+// sorted order, so the same fields are kept for the same input. Returns the
+// number of dropped fields.
+func capFields(fields map[string]interface{}, max_fields int, max_depth int) int {
+	budget := max_fields
+	if max_fields <= 0 {
+		budget = -1
+	}
+	return capObject(fields, 1, max_depth, &budget)
+}
+
+func capObject(fields map[string]interface{}, depth int, max_depth int, budget *int) int {
+	keys := make([]string, 0, len(fields))
+	for key := range fields {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	dropped := 0
+	for _, key := range keys {
+		if *budget == 0 {
+			delete(fields, key)
+			dropped++
+			continue
+		}
+		if *budget > 0 {
+			*budget--
+		}
+		fields[key], dropped = capValue(fields[key], depth, max_depth, budget, dropped)
+	}
+	return dropped
+}
+
+func capValue(value interface{}, depth int, max_depth int, budget *int, dropped int) (interface{}, int) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if max_depth > 0 && depth >= max_depth {
+			return flattenValue(v), dropped
+		}
+		return v, dropped + capObject(v, depth+1, max_depth, budget)
+	case []interface{}:
+		if max_depth > 0 && depth >= max_depth {
+			return flattenValue(v), dropped
+		}
+		for i := range v {
+			v[i], dropped = capValue(v[i], depth+1, max_depth, budget, dropped)
+		}
+	}
+	return value, dropped
+}
+
+func flattenValue(value interface{}) string {
+	js, _ := json.Marshal(value)
+	return string(js)
+}
@@ -0,0 +1,191 @@
+package redis
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("hello", truncateUTF8("hello", 10))
+	assert.Equal("hel", truncateUTF8("hello", 3))
+	// é is 2 bytes, € is 3 bytes
+	assert.Equal("h", truncateUTF8("hé", 2))
+	assert.Equal("hé", truncateUTF8("hé€", 5))
+	assert.Equal("", truncateUTF8("€", 2))
+}
+
+func TestSplitUTF8(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal([]string{"hel", "lo"}, splitUTF8("hello", 3))
+	assert.Equal([]string{"aé", "€", "b"}, splitUTF8("aé€b", 3))
+	assert.Equal([]string{"€", "€"}, splitUTF8("€€", 2))
+	assert.Nil(splitUTF8("", 3))
+
+	s := strings.Repeat("a€é", 1000)
+	chunks := splitUTF8(s, 100)
+	assert.Equal(s, strings.Join(chunks, ""))
+	for _, chunk := range chunks {
+		assert.True(len(chunk) <= 100)
+		assert.True(utf8.ValidString(chunk))
+	}
+}
+
+func TestCapFields(t *testing.T) {
+	assert := assert.New(t)
+
+	fields := map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": 2, "d": 3},
+		"e": 4,
+	}
+	assert.Equal(2, capFields(fields, 3, 0))
+	assert.Equal(map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": 2}}, fields)
+
+	fields = map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": map[string]interface{}{"d": 1}, "e": []interface{}{1, []interface{}{2}}},
+	}
+	assert.Equal(0, capFields(fields, 0, 2))
+	assert.Equal(map[string]interface{}{
+		"a": 1,
+		"b": map[string]interface{}{"c": `{"d":1}`, "e": `[1,[2]]`},
+	}, fields)
+
+	fields = map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": 1, "c": 2}}}
+	assert.Equal(1, capFields(fields, 2, 0))
+	assert.Equal(map[string]interface{}{"a": []interface{}{map[string]interface{}{"b": 1}}}, fields)
+}
+
+func TestCreateLogstashMessageTruncated(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := testMessage("6feffd9428dc", "stdout", `{"message":"`+strings.Repeat("€", 10)+`"}`, nil)
+
+	msg, _ := createLogstashMessage(m, &messageOptions{max_message_bytes: 20})
+	jq := makeQuery(msg)
+	assert.Equal(`{"message":"€€`, getString(jq, "message"))
+	truncated, _ := jq.Bool("truncated")
+	assert.True(truncated)
+	assert.Equal(len(m.Data), getInt(jq, "original_length"))
+	_, err := jq.Object("event")
+	assert.NotNil(err)
+
+	msg, _ = createLogstashMessage(m, &messageOptions{max_message_bytes: 20, use_v0: true})
+	jq = makeQuery(msg)
+	assert.Equal(`{"message":"€€`, getString(jq, "@message"))
+	truncated, _ = jq.Bool("@fields", "truncated")
+	assert.True(truncated)
+	assert.Equal(len(m.Data), getInt(jq, "@fields", "original_length"))
+
+	// small enough
+	msg, _ = createLogstashMessage(m, &messageOptions{max_message_bytes: 100})
+	jq = makeQuery(msg)
+	assert.Equal(strings.Repeat("€", 10), getString(jq, "message"))
+	_, err = jq.Bool("truncated")
+	assert.NotNil(err)
+
+}
+
+func TestCreateLogstashMessagesSplit(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := testMessage("6feffd9428dc", "stdout", strings.Repeat("0123456789", 5), nil)
+
+	events, err := createLogstashMessages(m, &messageOptions{max_message_bytes: 20, oversize: OVERSIZE_SPLIT})
+	assert.Nil(err)
+	assert.Len(events, 3)
+
+	var id string
+	for i, event := range events {
+		jq := makeQuery(event)
+		assert.Equal(m.Data[i*20:min(len(m.Data), (i+1)*20)], getString(jq, "message"))
+		assert.Equal(i+1, getInt(jq, "chunk", "index"))
+		assert.Equal(3, getInt(jq, "chunk", "count"))
+		if i == 0 {
+			id = getString(jq, "chunk", "id")
+			assert.Len(id, 32)
+		}
+		assert.Equal(id, getString(jq, "chunk", "id"))
+		_, err := jq.Bool("truncated")
+		assert.NotNil(err)
+	}
+
+	// small enough
+	events, _ = createLogstashMessages(m, &messageOptions{max_message_bytes: 100, oversize: OVERSIZE_SPLIT})
+	assert.Len(events, 1)
+	_, err = makeQuery(events[0]).Object("chunk")
+	assert.NotNil(err)
+
+	// truncate policy
+	events, _ = createLogstashMessages(m, &messageOptions{max_message_bytes: 20, oversize: OVERSIZE_TRUNCATE})
+	assert.Len(events, 1)
+	assert.Equal("01234567890123456789", getString(makeQuery(events[0]), "message"))
+
+}
+
+func TestCreateLogstashMessagesSplitInvalidUTF8(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := testMessage("6feffd9428dc", "stdout", strings.Repeat("\xff", 20), nil)
+
+	// every invalid byte takes three bytes repaired, and nothing is lost
+	events, err := createLogstashMessages(m, &messageOptions{max_message_bytes: 10, oversize: OVERSIZE_SPLIT})
+	assert.Nil(err)
+	assert.Len(events, 7)
+	var joined string
+	for _, event := range events {
+		jq := makeQuery(event)
+		joined += getString(jq, "message")
+		_, err := jq.Bool("truncated")
+		assert.NotNil(err)
+		tags, _ := jq.ArrayOfStrings("tags")
+		assert.Equal([]string{TAG_INVALID_UTF8}, tags)
+	}
+	assert.Equal(strings.Repeat("\ufffd", 20), joined)
+
+	events, _ = createLogstashMessages(m, &messageOptions{max_message_bytes: 10, oversize: OVERSIZE_SPLIT, use_v0: true})
+	assert.Len(events, 7)
+	tags, _ := makeQuery(events[0]).ArrayOfStrings("@fields", "tags")
+	assert.Equal([]string{TAG_INVALID_UTF8}, tags)
+
+	// the original length is that of the input
+	events, _ = createLogstashMessages(m, &messageOptions{max_message_bytes: 10})
+	jq := makeQuery(events[0])
+	assert.Equal(strings.Repeat("\ufffd", 3), getString(jq, "message"))
+	assert.Equal(20, getInt(jq, "original_length"))
+
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func TestCreateLogstashMessageWithCappedFields(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := testMessage("6feffd9428dc", "stdout", `{"message":"hi","a":1,"b":{"c":{"d":1}},"e":2}`, nil)
+
+	msg, _ := createLogstashMessage(m, &messageOptions{max_fields: 3, max_depth: 2})
+	jq := makeQuery(msg)
+	assert.Equal("hi", getString(jq, "message"))
+	assert.Equal(1, getInt(jq, "event", "a"))
+	assert.Equal(`{"d":1}`, getString(jq, "event", "b", "c"))
+	assert.Equal(0, getInt(jq, "event", "e"))
+	assert.Equal(1, getInt(jq, "dropped_fields"))
+	truncated, _ := jq.Bool("truncated")
+	assert.True(truncated)
+
+}
@@ -0,0 +1,138 @@
+package redis
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+)
+
+const (
+	LABEL_RATE_LIMIT             = "logspout.redis.rate_limit"
+	LABEL_RATE_BURST             = "logspout.redis.rate_burst"
+	TAG_RATE_LIMITED             = "_rate_limited"
+	SOURCE_LOGSPOUT              = "logspout"
+	DEFAULT_RATE_SUMMARY_SECONDS = 10
+)
+
+// tokenBucket limits the rate of a single container.
+type tokenBucket struct {
+	container *docker.Container
+	rate      float64
+	burst     float64
+	tokens    float64
+	last      time.Time
+	dropped   int
+}
+
+// rateLimiter limits the number of log lines per second per container, using
+// a token bucket per container id. The rate and burst can be overridden per
+// container with labels. A rate of 0 means unlimited.
+type rateLimiter struct {
+	rate          int
+	burst         int
+	interval      time.Duration
+	buckets       map[string]*tokenBucket
+	dropped_total int64
+}
+
+func newRateLimiter(rate int, burst int, interval time.Duration) *rateLimiter {
+	return &rateLimiter{
+		rate:     rate,
+		burst:    burst,
+		interval: interval,
+		buckets:  make(map[string]*tokenBucket),
+	}
+}
+
+// Allow reports whether m may be shipped, and counts it as dropped otherwise.
+func (l *rateLimiter) Allow(m *router.Message, now time.Time) bool {
+	bucket, ok := l.buckets[m.Container.ID]
+	if !ok {
+		bucket = l.newBucket(m.Container, now)
+		l.buckets[m.Container.ID] = bucket
+	}
+	if bucket.rate <= 0 {
+		return true
+	}
+
+	bucket.tokens = math.Min(bucket.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*bucket.rate)
+	bucket.last = now
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true
+	}
+	bucket.dropped++
+	atomic.AddInt64(&l.dropped_total, 1)
+	return false
+}
+
+func (l *rateLimiter) newBucket(container *docker.Container, now time.Time) *tokenBucket {
+	rate, burst := l.rate, l.burst
+	labels := container.Config.Labels
+	if value, ok := labels[LABEL_RATE_LIMIT]; ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			rate, burst = n, 0
+		}
+	}
+	if value, ok := labels[LABEL_RATE_BURST]; ok {
+		if n, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+			burst = n
+		}
+	}
+	if burst < 1 {
+		burst = int(math.Max(1, float64(rate)))
+	}
+	return &tokenBucket{
+		container: container,
+		rate:      float64(rate),
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      now,
+	}
+}
+
+// Summaries returns a synthetic log line for every container with dropped log
+// lines since the previous call, and forgets idle containers.
+func (l *rateLimiter) Summaries(now time.Time) []*router.Message {
+	var summaries []*router.Message
+	for id, bucket := range l.buckets {
+		if bucket.dropped > 0 {
+			summaries = append(summaries, l.summary(bucket, now))
+			bucket.dropped = 0
+			continue
+		}
+		// a bucket that would be full again can be recreated when needed
+		if bucket.rate <= 0 || now.Sub(bucket.last).Seconds()*bucket.rate >= bucket.burst {
+			delete(l.buckets, id)
+		}
+	}
+	return summaries
+}
+
+func (l *rateLimiter) summary(bucket *tokenBucket, now time.Time) *router.Message {
+	name := strings.TrimPrefix(bucket.container.Name, "/")
+	data, _ := json.Marshal(map[string]interface{}{
+		"message":    fmt.Sprintf("dropped %d messages from %s in the last %s", bucket.dropped, name, l.interval),
+		"dropped":    bucket.dropped,
+		"rate_limit": bucket.rate,
+		"rate_burst": bucket.burst,
+	})
+	return &router.Message{
+		Container: bucket.container,
+		Source:    SOURCE_LOGSPOUT,
+		Data:      string(data),
+		Time:      now,
+	}
+}
+
+// Dropped returns the total number of dropped log lines.
+func (l *rateLimiter) Dropped() int64 {
+	return atomic.LoadInt64(&l.dropped_total)
+}
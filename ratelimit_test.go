@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func countAllowed(l *rateLimiter, m *router.Message, n int, now time.Time) int {
+	allowed := 0
+	for i := 0; i < n; i++ {
+		if l.Allow(m, now) {
+			allowed++
+		}
+	}
+	return allowed
+}
+
+func TestRateLimiter(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	l := newRateLimiter(10, 20, 10*time.Second)
+	noisy := testMessage("noisy", "stdout", "hello", nil)
+	quiet := testMessage("quiet", "stdout", "hello", nil)
+
+	// the burst is available at once
+	assert.Equal(20, countAllowed(l, noisy, 100, now))
+	// other containers have their own bucket
+	assert.Equal(5, countAllowed(l, quiet, 5, now))
+
+	// tokens are refilled at the rate
+	now = now.Add(500 * time.Millisecond)
+	assert.Equal(5, countAllowed(l, noisy, 100, now))
+	// but never beyond the burst
+	now = now.Add(time.Minute)
+	assert.Equal(20, countAllowed(l, noisy, 100, now))
+
+	assert.Equal(int64(255), l.Dropped())
+}
+
+func TestRateLimiterLabels(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	l := newRateLimiter(10, 0, 10*time.Second)
+
+	// burst defaults to the rate
+	assert.Equal(10, countAllowed(l, testMessage("a", "stdout", "hello", nil), 100, now))
+	assert.Equal(3, countAllowed(l, testMessage("b", "stdout", "hello", map[string]string{LABEL_RATE_LIMIT: "3"}), 100, now))
+	assert.Equal(50, countAllowed(l, testMessage("c", "stdout", "hello", map[string]string{LABEL_RATE_LIMIT: "5", LABEL_RATE_BURST: "50"}), 100, now))
+	assert.Equal(100, countAllowed(l, testMessage("d", "stdout", "hello", map[string]string{LABEL_RATE_LIMIT: "0"}), 100, now))
+	assert.Equal(10, countAllowed(l, testMessage("e", "stdout", "hello", map[string]string{LABEL_RATE_LIMIT: "many"}), 100, now))
+
+	// labels can enable rate limiting too
+	l = newRateLimiter(0, 0, 10*time.Second)
+	assert.Equal(100, countAllowed(l, testMessage("a", "stdout", "hello", nil), 100, now))
+	assert.Equal(2, countAllowed(l, testMessage("b", "stdout", "hello", map[string]string{LABEL_RATE_LIMIT: "2"}), 100, now))
+}
+
+func TestRateLimiterSummaries(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Unix(1453813310, 0)
+	l := newRateLimiter(10, 10, 10*time.Second)
+	countAllowed(l, testMessage("noisy", "stdout", "hello", nil), 25, now)
+	countAllowed(l, testMessage("quiet", "stdout", "hello", nil), 5, now)
+
+	summaries := l.Summaries(now.Add(time.Second))
+	assert.Len(summaries, 1)
+	summary := summaries[0]
+	assert.Equal("noisy", summary.Container.ID)
+	assert.Equal(SOURCE_LOGSPOUT, summary.Source)
+	assert.Equal(now.Add(time.Second), summary.Time)
+
+	msg, err := createSyntheticMessage(summary, &messageOptions{}, TAG_RATE_LIMITED)
+	assert.Nil(err)
+	jq := makeQuery(msg)
+	assert.Equal("dropped 15 messages from noisy in the last 10s", getString(jq, "message"))
+	assert.Equal(15, getInt(jq, "event", "dropped"))
+	assert.Equal(10, getInt(jq, "event", "rate_limit"))
+	assert.Equal("noisy", getString(jq, "docker", "name"))
+	tags, _ := jq.ArrayOfStrings("tags")
+	assert.Equal([]string{TAG_RATE_LIMITED}, tags)
+
+	// counts are reset, and idle containers forgotten
+	assert.Len(l.Summaries(now.Add(20*time.Second)), 0)
+	assert.Len(l.buckets, 0)
+}
+
+func TestCreateSyntheticMessageIsNotParsed(t *testing.T) {
+	assert := assert.New(t)
+
+	m := testMessage("app", "stdout", "hello", nil)
+	m.Data = `{"message":"synthetic","level":"warn"}`
+	opts := &messageOptions{level_field: "level", max_fields: 1, redactor: &redactor{}}
+	msg, _ := createSyntheticMessage(m, opts, "_test")
+	jq := makeQuery(msg)
+	assert.Equal("synthetic", getString(jq, "message"))
+	assert.Equal("warn", getString(jq, "event", "level"))
+	assert.Equal("", getString(jq, "level"))
+	assert.Nil(opts.tags)
+}
@@ -0,0 +1,283 @@
+package redis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	REDACT_MASK   = "mask"
+	REDACT_HASH   = "hash"
+	REDACT_REMOVE = "remove"
+)
+
+// redactBuiltins are the detectors that can be enabled by name. When a pattern
+// has a group, only the text of the first group is redacted.
+var redactBuiltins = map[string]struct {
+	pattern string
+	fields  string
+	valid   func(string) bool
+}{
+	"email":         {pattern: `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`},
+	"ipv4":          {pattern: `\b` + grokBuiltins["IPV4"] + `\b`},
+	"ipv6":          {pattern: `[0-9A-Fa-f:]*:[0-9A-Fa-f:]*:[0-9A-Fa-f:.]*`, valid: validIPv6},
+	"creditcard":    {pattern: `\b[2-6](?:[ \-]?\d){12,18}\b`, valid: validLuhn},
+	"iban":          {pattern: `\b[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){2,7}(?: ?[A-Z0-9]{1,3})?\b`, valid: validIBAN},
+	"jwt":           {pattern: `\beyJ[A-Za-z0-9_\-]+\.eyJ[A-Za-z0-9_\-]+\.[A-Za-z0-9_\-]*`},
+	"authorization": {pattern: `(?i)\bauthorization"?\s*[:=]\s*"?(?:(?:bearer|basic|digest|token)\s+)?([^\s",]+)`, fields: "authorization"},
+}
+
+// redactRule replaces text matching pattern, and the full string values of
+// fields with a name matching fields (case insensitive).
+type redactRule struct {
+	name    string
+	pattern *regexp.Regexp
+	fields  globList
+	valid   func(string) bool
+	action  string
+	salt    string
+	count   int64
+}
+
+func (r *redactRule) replacement(value string) string {
+	atomic.AddInt64(&r.count, 1)
+	switch r.action {
+	case REDACT_HASH:
+		sum := sha256.Sum256([]byte(r.salt + value))
+		return "sha256:" + hex.EncodeToString(sum[:8])
+	case REDACT_REMOVE:
+		return ""
+	default:
+		return "[REDACTED:" + r.name + "]"
+	}
+}
+
+func (r *redactRule) Redact(s string) string {
+	if r.pattern == nil {
+		return s
+	}
+	matches := r.pattern.FindAllStringSubmatchIndex(s, -1)
+	if matches == nil {
+		return s
+	}
+
+	var buf []byte
+	last, replaced := 0, false
+	for _, loc := range matches {
+		start, end := loc[0], loc[1]
+		if len(loc) > 2 && loc[2] >= 0 {
+			start, end = loc[2], loc[3]
+		}
+		if r.valid != nil && !r.valid(s[start:end]) {
+			continue
+		}
+		buf = append(buf, s[last:start]...)
+		buf = append(buf, r.replacement(s[start:end])...)
+		last, replaced = end, true
+	}
+	if !replaced {
+		return s
+	}
+	return string(append(buf, s[last:]...))
+}
+
+// redactor applies a list of redaction rules, in order, to messages and field
+// values.
+type redactor struct {
+	rules []*redactRule
+}
+
+// newRedactor creates a redactor with the named builtin detectors, followed by
+// the rules of rules_file (if set).
+func newRedactor(builtins string, rules_file string, action string, salt string) (*redactor, error) {
+	if !validRedactAction(action) {
+		return nil, fmt.Errorf("unknown redact action %s", action)
+	}
+
+	r := &redactor{}
+	for _, name := range splitList(builtins) {
+		builtin, ok := redactBuiltins[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redact detector %s", name)
+		}
+		r.rules = append(r.rules, &redactRule{
+			name:    name,
+			pattern: regexp.MustCompile(builtin.pattern),
+			fields:  newGlobList(builtin.fields),
+			valid:   builtin.valid,
+			action:  action,
+			salt:    salt,
+		})
+	}
+
+	if rules_file != "" {
+		rules, err := loadRedactRules(rules_file, action, salt)
+		if err != nil {
+			return nil, err
+		}
+		r.rules = append(r.rules, rules...)
+	}
+	return r, nil
+}
+
+// redactRulesFile is the layout of the JSON file with redaction rules, e.g.
+//
+//	{
+//	  "rules": [
+//	    {"name": "customer", "pattern": "CUST-[0-9]+", "action": "hash"},
+//	    {"name": "secrets", "field": "password,*_secret", "action": "remove"}
+//	  ]
+//	}
+type redactRulesFile struct {
+	Rules []struct {
+		Name    string `json:"name"`
+		Pattern string `json:"pattern"`
+		Field   string `json:"field"`
+		Action  string `json:"action"`
+	} `json:"rules"`
+}
+
+func loadRedactRules(filename string, action string, salt string) ([]*redactRule, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var file redactRulesFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("%s: %v", filename, err)
+	}
+
+	var rules []*redactRule
+	for i, r := range file.Rules {
+		rule := &redactRule{name: r.Name, fields: newGlobList(strings.ToLower(r.Field)), action: r.Action, salt: salt}
+		if rule.name == "" {
+			rule.name = fmt.Sprintf("rule%d", i+1)
+		}
+		if rule.action == "" {
+			rule.action = action
+		}
+		if !validRedactAction(rule.action) {
+			return nil, fmt.Errorf("%s: rule %s has unknown action %s", filename, rule.name, rule.action)
+		}
+		if r.Pattern == "" && r.Field == "" {
+			return nil, fmt.Errorf("%s: rule %s has no pattern or field", filename, rule.name)
+		}
+		if r.Pattern != "" {
+			rule.pattern, err = regexp.Compile(r.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("%s: rule %s: %v", filename, rule.name, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func validRedactAction(action string) bool {
+	return action == REDACT_MASK || action == REDACT_HASH || action == REDACT_REMOVE
+}
+
+func (r *redactor) Redact(s string) string {
+	for _, rule := range r.rules {
+		s = rule.Redact(s)
+	}
+	return s
+}
+
+// RedactFields redacts all string values in fields, including those in nested
+// objects and arrays.
+func (r *redactor) RedactFields(fields map[string]interface{}) {
+	for key, value := range fields {
+		fields[key] = r.redactValue(strings.ToLower(key), value)
+	}
+}
+
+func (r *redactor) redactValue(key string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case string:
+		for _, rule := range r.rules {
+			if key != "" && rule.fields.Match(key) {
+				return rule.replacement(v)
+			}
+		}
+		return r.Redact(v)
+	case map[string]interface{}:
+		r.RedactFields(v)
+	case []interface{}:
+		for i := range v {
+			v[i] = r.redactValue(key, v[i])
+		}
+	}
+	return value
+}
+
+// Counts returns the number of redactions per rule.
+func (r *redactor) Counts() map[string]int64 {
+	counts := make(map[string]int64, len(r.rules))
+	for _, rule := range r.rules {
+		counts[rule.name] += atomic.LoadInt64(&rule.count)
+	}
+	return counts
+}
+
+// validIPv6 accepts IPv6 addresses with at least two groups, so "::" in source
+// code (e.g. Foo::bar) is not taken for an address.
+func validIPv6(s string) bool {
+	groups := 0
+	for _, group := range strings.Split(s, ":") {
+		if group != "" {
+			groups++
+		}
+	}
+	return groups >= 2 && net.ParseIP(s) != nil
+}
+
+// validLuhn checks the Luhn checksum of the digits in s.
+func validLuhn(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n >= 13 && n <= 19 && sum%10 == 0
+}
+
+// validIBAN checks the mod 97 checksum of an IBAN.
+func validIBAN(s string) bool {
+	s = strings.Replace(s, " ", "", -1)
+	if len(s) < 15 || len(s) > 34 {
+		return false
+	}
+	var digits []byte
+	for _, c := range []byte(s[4:] + s[:4]) {
+		switch {
+		case c >= '0' && c <= '9':
+			digits = append(digits, c)
+		case c >= 'A' && c <= 'Z':
+			digits = append(digits, []byte(fmt.Sprint(int(c-'A')+10))...)
+		default:
+			return false
+		}
+	}
+	n, ok := new(big.Int).SetString(string(digits), 10)
+	return ok && new(big.Int).Mod(n, big.NewInt(97)).Int64() == 1
+}
@@ -10,10 +10,11 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/garyburd/redigo/redis"
@@ -24,35 +25,92 @@ const (
 	NO_MESSAGE_PROVIDED     = "no message"
 	LOGTYPE_APPLICATIONLOG  = "applog"
 	LOGTYPE_ACCESSLOG       = "accesslog"
+	LOGTYPE_EVENT           = "event"
+	DEFAULT_LOGTYPE_FIELD   = "logtype"
 	DEFAULT_CONNECT_TIMEOUT = 100
 	DEFAULT_READ_TIMEOUT    = 300
 	DEFAULT_WRITE_TIMEOUT   = 500
+	NESTED_LABEL_VALUE_KEY  = "_value"
 )
 
 type RedisAdapter struct {
-	route         *router.Route
-	pool          *redis.Pool
-	key           string
-	docker_host   string
-	use_v0        bool
-	logstash_type string
-	dedot_labels  bool
-	mute_errors   bool
-	msg_counter   int
+	route       *router.Route
+	pool        *redis.Pool
+	key         string
+	msg_opts    *messageOptions
+	msg_counter int
+
+	skip_kubernetes_infra bool
+	sanitizer             *sanitizer
+	overrides             *overrideCache
+	filter                *messageFilter
+	dedup                 *deduplicator
+	sampler               *sampler
+	rate_limiter          *rateLimiter
+	multiline             *multilineAggregator
+	metrics               *adapterMetrics
+	heartbeat             *heartbeat
+	stamper               *eventStamper
+	log                   *leveledLogger
+}
+
+// messageOptions controls how a router.Message is turned into a Logstash event.
+type messageOptions struct {
+	docker_host          string
+	use_v0               bool
+	logstash_type        string
+	dedot_labels         bool
+	nest_labels          bool
+	include_labels       globList
+	exclude_labels       globList
+	strip_label_prefixes []string
+	include_env          globList
+	kubernetes           bool
+	compose              bool
+	swarm                bool
+	logtypes             *logtypeRegistry
+	timestamp_field      string
+	timestamp_parser     *timestampParser
+	level_field          string
+	parse                string
+	grok_rules           grokRules
+	json_prefix          *regexp.Regexp
+	json_arrays          string
+	json_array_field     string
+	max_message_bytes    int
+	oversize             string
+	max_fields           int
+	max_depth            int
+	redactor             *redactor
+	tags                 []string
+	sample_rate          float64
+	repeat               *RepeatFields
+	logtype              string
+	meta                 *MetaFields
 }
 
 type DockerFields struct {
-	Name       string            `json:"name"`
-	CID        string            `json:"cid"`
-	Image      string            `json:"image"`
-	ImageTag   string            `json:"image_tag,omitempty"`
-	Source     string            `json:"source"`
-	DockerHost string            `json:"docker_host,omitempty"`
-	Labels     map[string]string `json:"labels,omitempty"`
+	Name       string                 `json:"name"`
+	CID        string                 `json:"cid"`
+	Image      string                 `json:"image"`
+	ImageTag   string                 `json:"image_tag,omitempty"`
+	Source     string                 `json:"source"`
+	DockerHost string                 `json:"docker_host,omitempty"`
+	Labels     map[string]interface{} `json:"labels,omitempty"`
+	Env        map[string]string      `json:"env,omitempty"`
+	ReceivedAt string                 `json:"received_at,omitempty"`
 }
 
 type LogstashFields struct {
-	Docker DockerFields `json:"docker"`
+	Docker         DockerFields `json:"docker"`
+	Logtype        string       `json:"logtype,omitempty"`
+	Tags           []string     `json:"tags,omitempty"`
+	Truncated      bool         `json:"truncated,omitempty"`
+	OriginalLength int          `json:"original_length,omitempty"`
+	Chunk          *ChunkFields `json:"chunk,omitempty"`
+	SampleRate     float64      `json:"sample_rate,omitempty"`
+	*RepeatFields
+	*MetaFields
 }
 
 type LogstashMessageV0 struct {
@@ -64,20 +122,32 @@ type LogstashMessageV0 struct {
 }
 
 type LogstashMessageV1 struct {
-	Type       string       `json:"@type,omitempty"`
-	Timestamp  string       `json:"@timestamp"`
-	Sourcehost string       `json:"host"`
-	Message    string       `json:"message"`
-	Fields     DockerFields `json:"docker"`
-	Logtype    string       `json:"logtype,omitempty"`
-	// Only one of the following 3 is initialized and used, depending on the incoming json:logtype
-	LogtypeAccessfields map[string]interface{} `json:"accesslog,omitempty"`
-	LogtypeAppfields    map[string]interface{} `json:"applog,omitempty"`
-	LogtypeEventfields  map[string]interface{} `json:"event,omitempty"`
+	Type           string            `json:"@type,omitempty"`
+	Timestamp      string            `json:"@timestamp"`
+	Sourcehost     string            `json:"host"`
+	Message        string            `json:"message"`
+	Level          string            `json:"level,omitempty"`
+	Fields         DockerFields      `json:"docker"`
+	Kubernetes     *KubernetesFields `json:"kubernetes,omitempty"`
+	Compose        *ComposeFields    `json:"compose,omitempty"`
+	Swarm          *SwarmFields      `json:"swarm,omitempty"`
+	Logtype        string            `json:"logtype,omitempty"`
+	Tags           []string          `json:"tags,omitempty"`
+	Truncated      bool              `json:"truncated,omitempty"`
+	OriginalLength int               `json:"original_length,omitempty"`
+	DroppedFields  int               `json:"dropped_fields,omitempty"`
+	Chunk          *ChunkFields      `json:"chunk,omitempty"`
+	SampleRate     float64           `json:"sample_rate,omitempty"`
+	*RepeatFields
+	*MetaFields
+	// Fields of the incoming json, marshaled under the name of the logtype (see MarshalJSON)
+	LogtypeFields map[string]interface{} `json:"-"`
 }
 
 func init() {
 	router.AdapterFactories.Register(NewRedisAdapter, "redis")
+	router.HttpHandlers.Register(metricsHandler, METRICS_PATH)
+	router.HttpHandlers.Register(statusHandler, STATUS_PATH)
 }
 
 func NewRedisAdapter(route *router.Route) (router.LogAdapter, error) {
@@ -94,31 +164,215 @@ func NewRedisAdapter(route *router.Route) (router.LogAdapter, error) {
 	docker_host := getopt(route.Options, "docker_host", "REDIS_DOCKER_HOST", "")
 	use_v0 := getopt(route.Options, "use_v0_layout", "REDIS_USE_V0_LAYOUT", "") != ""
 	logstash_type := getopt(route.Options, "logstash_type", "REDIS_LOGSTASH_TYPE", "")
-	dedot_labels := getopt(route.Options, "dedot_labels", "DEDOT_LABELS", "false") == "true"
-	debug := getopt(route.Options, "debug", "DEBUG", "") != ""
-	mute_errors := getopt(route.Options, "mute_errors", "MUTE_ERRORS", "true") == "true"
+	dedot_mode := getopt(route.Options, "dedot_labels", "DEDOT_LABELS", "false")
+	dedot_labels := dedot_mode == "true"
+	nest_labels := dedot_mode == "nest"
+	include_labels := getopt(route.Options, "include_labels", "INCLUDE_LABELS", "")
+	exclude_labels := getopt(route.Options, "exclude_labels", "EXCLUDE_LABELS", "")
+	strip_label_prefix := getopt(route.Options, "strip_label_prefix", "STRIP_LABEL_PREFIX", "")
+	include_env := getopt(route.Options, "include_env", "INCLUDE_ENV", "")
+	kubernetes := getopt(route.Options, "kubernetes", "KUBERNETES", "false") == "true"
+	skip_kubernetes_infra := getopt(route.Options, "skip_kubernetes_infra", "SKIP_KUBERNETES_INFRA", "false") == "true"
+	compose := getopt(route.Options, "compose", "COMPOSE", "false") == "true"
+	swarm := getopt(route.Options, "swarm", "SWARM", "false") == "true"
+	logtype_field := getopt(route.Options, "logtype_field", "LOGTYPE_FIELD", DEFAULT_LOGTYPE_FIELD)
+	logtypes_s := getopt(route.Options, "logtypes", "LOGTYPES", LOGTYPE_APPLICATIONLOG+","+LOGTYPE_ACCESSLOG)
+	timestamp_field := getopt(route.Options, "timestamp_field", "TIMESTAMP_FIELD", "")
+	timestamp_formats := getopt(route.Options, "timestamp_formats", "TIMESTAMP_FORMATS", TIMESTAMP_RFC3339)
+	level_field := getopt(route.Options, "level_field", "LEVEL_FIELD", "")
+	multiline_pattern := getopt(route.Options, "multiline_pattern", "MULTILINE_PATTERN", "")
+	multiline_continue := getopt(route.Options, "multiline_continue", "MULTILINE_CONTINUE", "")
+	multiline_max_lines := getintopt(route.Options, "multiline_max_lines", "MULTILINE_MAX_LINES", DEFAULT_MULTILINE_MAX_LINES)
+	multiline_max_bytes := getintopt(route.Options, "multiline_max_bytes", "MULTILINE_MAX_BYTES", DEFAULT_MULTILINE_MAX_BYTES)
+	multiline_timeout := getintopt(route.Options, "multiline_timeout", "MULTILINE_TIMEOUT", DEFAULT_MULTILINE_TIMEOUT)
+	json_prefix_s := getopt(route.Options, "json_prefix", "JSON_PREFIX", "")
+	json_arrays := getopt(route.Options, "json_arrays", "JSON_ARRAYS", "")
+	json_array_field := getopt(route.Options, "json_array_field", "JSON_ARRAY_FIELD", DEFAULT_JSON_ARRAY_FIELD)
+	parse := getopt(route.Options, "parse", "PARSE", "")
+	max_message_bytes := getintopt(route.Options, "max_message_bytes", "MAX_MESSAGE_BYTES", 0)
+	oversize := getopt(route.Options, "oversize", "OVERSIZE", OVERSIZE_TRUNCATE)
+	max_fields := getintopt(route.Options, "max_fields", "MAX_FIELDS", 0)
+	max_depth := getintopt(route.Options, "max_depth", "MAX_DEPTH", 0)
+	grok_rules_file := getopt(route.Options, "grok_rules", "GROK_RULES", "")
+	dedup_window := getintopt(route.Options, "dedup_window", "DEDUP_WINDOW", 0)
+	dedup_normalize := getopt(route.Options, "dedup_normalize", "DEDUP_NORMALIZE", "false") == "true"
+	dedup_max_entries := getintopt(route.Options, "dedup_max_entries", "DEDUP_MAX_ENTRIES", DEFAULT_DEDUP_MAX_ENTRIES)
+	sample := getopt(route.Options, "sample", "SAMPLE", "")
+	rate_limit := getintopt(route.Options, "rate_limit", "RATE_LIMIT", 0)
+	rate_burst := getintopt(route.Options, "rate_burst", "RATE_BURST", 0)
+	filter_s := getopt(route.Options, "filter", "FILTER", "")
+	filter_rules_file := getopt(route.Options, "filter_rules", "FILTER_RULES", "")
+	filter_default := getopt(route.Options, "filter_default", "FILTER_DEFAULT", FILTER_KEEP)
+	redact := getopt(route.Options, "redact", "REDACT", "")
+	redact_rules_file := getopt(route.Options, "redact_rules", "REDACT_RULES", "")
+	redact_action := getopt(route.Options, "redact_action", "REDACT_ACTION", REDACT_MASK)
+	redact_salt := getopt(route.Options, "redact_salt", "REDACT_SALT", "")
+	strip_ansi := getopt(route.Options, "strip_ansi", "STRIP_ANSI", "false") == "true"
+	strip_control := getopt(route.Options, "strip_control", "STRIP_CONTROL", "false") == "true"
+	log_level := defaultLogLevel(route.Options)
+	log_format := getopt(route.Options, "log_format", "LOG_FORMAT", LOG_FORMAT_TEXT)
+	event_ids := getopt(route.Options, "event_ids", "EVENT_IDS", "")
+	sequence_file := getopt(route.Options, "sequence_file", "SEQUENCE_FILE", "")
+	heartbeat_interval := getintopt(route.Options, "heartbeat_interval", "HEARTBEAT_INTERVAL", 0)
+	heartbeat_key := getopt(route.Options, "heartbeat_key", "HEARTBEAT_KEY", key)
+	health_timeout := getintopt(route.Options, "health_timeout", "HEALTH_TIMEOUT", DEFAULT_HEALTH_TIMEOUT)
+	// mute_errors=false is kept to log every error
+	log_rate_interval := DEFAULT_LOG_RATE_INTERVAL
+	if getopt(route.Options, "mute_errors", "MUTE_ERRORS", "true") != "true" {
+		log_rate_interval = 0
+	}
+	log_rate_interval = getintopt(route.Options, "log_rate_interval", "LOG_RATE_INTERVAL", log_rate_interval)
 
 	connect_timeout := getintopt(route.Options, "connect_timeout", "CONNECT_TIMEOUT", DEFAULT_CONNECT_TIMEOUT)
 	read_timeout := getintopt(route.Options, "read_timeout", "READ_TIMEOUT", DEFAULT_READ_TIMEOUT)
 	write_timeout := getintopt(route.Options, "write_timeout", "WRITE_TIMEOUT", DEFAULT_WRITE_TIMEOUT)
 
+	logger, err := newLeveledLogger(log_level, log_format, time.Duration(log_rate_interval)*time.Second, route.ID)
+	if err != nil {
+		return nil, errorf("Invalid logging config: %v. Please verify & fix", err)
+	}
+
 	database_s := getopt(route.Options, "database", "REDIS_DATABASE", "0")
 	database, err := strconv.Atoi(database_s)
 	if err != nil {
 		return nil, errorf("Invalid Redis database number specified: %s. Please verify & fix", database_s)
 	}
 
-	if debug {
-		log.Printf("Using Redis server '%s', dbnum: %d, password?: %t, pushkey: '%s', v0 layout?: %t, logstash type: '%s'\n",
-			address, database, password != "", key, use_v0, logstash_type)
-        log.Printf("Dedotting docker labels: %t", dedot_labels)
-		log.Printf("Timeouts set, connect: %dms, read: %dms, write: %dms\n", connect_timeout, read_timeout, write_timeout)
+	logtypes, err := newLogtypeRegistry(logtype_field, logtypes_s)
+	if err != nil {
+		return nil, errorf("Invalid logtypes specified: %v. Please verify & fix", err)
+	}
+
+	if parse != "" && parse != PARSE_LOGFMT {
+		return nil, errorf("Invalid parse format specified: %s. Please verify & fix", parse)
+	}
+
+	var json_prefix *regexp.Regexp
+	if json_prefix_s != "" {
+		json_prefix, err = regexp.Compile(json_prefix_s)
+		if err != nil {
+			return nil, errorf("Invalid json_prefix regex specified: %v. Please verify & fix", err)
+		}
+	}
+	if json_arrays != "" && json_arrays != JSON_ARRAYS_SPLIT && json_arrays != JSON_ARRAYS_FIELD {
+		return nil, errorf("Invalid json_arrays policy specified: %s. Please verify & fix", json_arrays)
+	}
+
+	if oversize != OVERSIZE_TRUNCATE && oversize != OVERSIZE_SPLIT {
+		return nil, errorf("Invalid oversize policy specified: %s. Please verify & fix", oversize)
+	}
+
+	var grok_rules grokRules
+	if grok_rules_file != "" {
+		grok_rules, err = loadGrokRules(grok_rules_file)
+		if err != nil {
+			return nil, errorf("Invalid grok rules: %v. Please verify & fix", err)
+		}
+	}
+
+	var filter *messageFilter
+	if filter_s != "" || filter_rules_file != "" || filter_default != FILTER_KEEP {
+		filter, err = newMessageFilter(filter_s, filter_rules_file, filter_default, json_prefix)
+		if err != nil {
+			return nil, errorf("Invalid filter config: %v. Please verify & fix", err)
+		}
+	}
+
+	var dedup *deduplicator
+	if dedup_window > 0 {
+		dedup = newDeduplicator(time.Duration(dedup_window)*time.Millisecond, dedup_normalize, dedup_max_entries)
+	}
+
+	var sampler *sampler
+	if sample != "" {
+		sample_level_field := level_field
+		if sample_level_field == "" {
+			sample_level_field = DEFAULT_SAMPLE_LEVEL_FIELD
+		}
+		sampler, err = newSampler(sample, sample_level_field, json_prefix)
+		if err != nil {
+			return nil, errorf("Invalid sample config: %v. Please verify & fix", err)
+		}
+	}
+
+	var redactor *redactor
+	if redact != "" || redact_rules_file != "" {
+		redactor, err = newRedactor(redact, redact_rules_file, redact_action, redact_salt)
+		if err != nil {
+			return nil, errorf("Invalid redaction config: %v. Please verify & fix", err)
+		}
+	}
+
+	var stamper *eventStamper
+	if event_ids != "" {
+		if event_ids != EVENT_ID_UUID && event_ids != EVENT_ID_ULID {
+			return nil, errorf("Invalid event_ids specified: %s. Please verify & fix", event_ids)
+		}
+		sequences, err := openSequenceStore(sequence_file)
+		if err != nil {
+			return nil, errorf("Cannot read sequence file: %v. Please verify & fix", err)
+		}
+		stamper = &eventStamper{
+			ids:       event_ids,
+			route:     fmt.Sprintf("%s/%d/%s", address, database, key),
+			sequences: sequences,
+			log:       logger,
+		}
+	}
+
+	var clean *sanitizer
+	if strip_ansi || strip_control {
+		clean = &sanitizer{strip_ansi: strip_ansi, strip_control: strip_control}
+	}
+
+	var multiline *multilineAggregator
+	if multiline_pattern != "" || multiline_continue != "" {
+		if multiline_pattern != "" && multiline_continue != "" {
+			return nil, errorf("Both multiline_pattern and multiline_continue specified. Please verify & fix")
+		}
+		var start, cont *regexp.Regexp
+		if multiline_pattern != "" {
+			start, err = regexp.Compile(multiline_pattern)
+		} else {
+			cont, err = regexp.Compile(multiline_continue)
+		}
+		if err != nil {
+			return nil, errorf("Invalid multiline regex specified: %v. Please verify & fix", err)
+		}
+		multiline = newMultilineAggregator(start, cont, multiline_max_lines, multiline_max_bytes,
+			time.Duration(multiline_timeout)*time.Millisecond)
 	}
+
+	logger.Debugf("Using Redis server '%s', dbnum: %d, password?: %t, pushkey: '%s', v0 layout?: %t, logstash type: '%s'\n",
+		address, database, password != "", key, use_v0, logstash_type)
+	logger.Debugf("Dedotting docker labels: %s", dedot_mode)
+	logger.Debugf("Label filters, include: '%s', exclude: '%s', strip prefix: '%s'\n", include_labels, exclude_labels, strip_label_prefix)
+	logger.Debugf("Including container environment variables: '%s'\n", include_env)
+	logger.Debugf("Kubernetes metadata: %t, skip infra containers: %t\n", kubernetes, skip_kubernetes_infra)
+	logger.Debugf("Compose metadata: %t, swarm metadata: %t\n", compose, swarm)
+	logger.Debugf("Logtypes: '%s', selected by field: '%s'\n", logtypes_s, logtype_field)
+	logger.Debugf("Timestamp field: '%s', formats: '%s', level field: '%s'\n", timestamp_field, timestamp_formats, level_field)
+	logger.Debugf("JSON prefix: '%s', arrays: '%s', array field: '%s'\n", json_prefix_s, json_arrays, json_array_field)
+	logger.Debugf("Parsing plain text messages as: '%s', grok rules: '%s' (%d rules)\n", parse, grok_rules_file, len(grok_rules))
+	logger.Debugf("Strip ANSI escape codes: %t, strip control characters: %t\n", strip_ansi, strip_control)
+	logger.Debugf("Dedup window: %dms, normalize: %t, max entries: %d\n", dedup_window, dedup_normalize, dedup_max_entries)
+	logger.Debugf("Sample: '%s'\n", sample)
+	logger.Debugf("Rate limit per container: %d/s, burst: %d\n", rate_limit, rate_burst)
+	logger.Debugf("Filter: '%s', rules: '%s', default: '%s'\n", filter_s, filter_rules_file, filter_default)
+	logger.Debugf("Redact: '%s', rules: '%s', action: '%s'\n", redact, redact_rules_file, redact_action)
+	logger.Debugf("Max message bytes: %d (%s), max fields: %d, max depth: %d\n", max_message_bytes, oversize, max_fields, max_depth)
+	logger.Debugf("Multiline start: '%s', continue: '%s', max lines: %d, max bytes: %d, timeout: %dms\n",
+		multiline_pattern, multiline_continue, multiline_max_lines, multiline_max_bytes, multiline_timeout)
+	logger.Debugf("Event ids: '%s', sequence file: '%s'\n", event_ids, sequence_file)
+	logger.Debugf("Heartbeat interval: %ds, key: '%s'\n", heartbeat_interval, heartbeat_key)
+	logger.Debugf("Health timeout: %ds\n", health_timeout)
+	logger.Debugf("Log level: %s, format: %s, errors rate limited to one per %ds\n", log_level, log_format, log_rate_interval)
+	logger.Debugf("Timeouts set, connect: %dms, read: %dms, write: %dms\n", connect_timeout, read_timeout, write_timeout)
 	if connect_timeout+read_timeout+write_timeout > 950 {
-		log.Printf("WARN: sum of connect, read & write timeouts > 950 ms. You risk loosing container logs as Logspout stops pumping logs after a 1.0 second timeout.")
+		logger.Warnf("sum of connect, read & write timeouts > 950 ms. You risk loosing container logs as Logspout stops pumping logs after a 1.0 second timeout.")
 	}
 
-	pool := newRedisConnectionPool(address, password, database, connect_timeout, read_timeout, write_timeout)
+	pool := newRedisConnectionPool(address, password, database, connect_timeout, read_timeout, write_timeout, logger)
 
 	// lets test the water
 	conn := pool.Get()
@@ -127,89 +381,339 @@ func NewRedisAdapter(route *router.Route) (router.LogAdapter, error) {
 	if err != nil {
 		return nil, errorf("Cannot connect to Redis server %s: %v", address, err)
 	}
-	if debug {
-		log.Printf("Redis connect successful, got response: %s\n", res)
+	logger.Debugf("Redis connect successful, got response: %s\n", res)
+
+	msg_opts := &messageOptions{
+		docker_host:          docker_host,
+		use_v0:               use_v0,
+		logstash_type:        logstash_type,
+		dedot_labels:         dedot_labels,
+		nest_labels:          nest_labels,
+		include_labels:       newGlobList(include_labels),
+		exclude_labels:       newGlobList(exclude_labels),
+		strip_label_prefixes: splitList(strip_label_prefix),
+		include_env:          newGlobList(include_env),
+		kubernetes:           kubernetes,
+		compose:              compose,
+		swarm:                swarm,
+		logtypes:             logtypes,
+		timestamp_field:      timestamp_field,
+		timestamp_parser:     newTimestampParser(timestamp_formats),
+		level_field:          level_field,
+		parse:                parse,
+		grok_rules:           grok_rules,
+		json_prefix:          json_prefix,
+		json_arrays:          json_arrays,
+		json_array_field:     json_array_field,
+		max_message_bytes:    max_message_bytes,
+		oversize:             oversize,
+		max_fields:           max_fields,
+		max_depth:            max_depth,
+		redactor:             redactor,
+	}
+
+	// per container labels can set a multiline pattern, also when not set for the route
+	overrides := newOverrideCache(key, msg_opts)
+	overrides.log = logger
+	if multiline == nil {
+		multiline = newMultilineAggregator(nil, nil, multiline_max_lines, multiline_max_bytes,
+			time.Duration(multiline_timeout)*time.Millisecond)
+	}
+	multiline.overrides = overrides
+
+	metrics := newAdapterMetrics(route.ID, address)
+	metrics.filter = filter
+	metrics.redactor = redactor
+	metrics.health_timeout = time.Duration(health_timeout) * time.Second
+	metrics.pool = func() (int, int) { return pool.ActiveCount(), pool.IdleCount() }
+
+	var beat *heartbeat
+	if heartbeat_interval > 0 {
+		beat, err = newHeartbeat(time.Duration(heartbeat_interval)*time.Second, heartbeat_key, metrics)
+		if err != nil {
+			return nil, errorf("Invalid Docker client config for heartbeats: %v. Please verify & fix", err)
+		}
+		beat.log = logger
 	}
 
 	return &RedisAdapter{
-		route:         route,
-		pool:          pool,
-		key:           key,
-		docker_host:   docker_host,
-		use_v0:        use_v0,
-		logstash_type: logstash_type,
-		dedot_labels:  dedot_labels,
-		mute_errors:   mute_errors,
-		msg_counter:   0,
+		route:       route,
+		pool:        pool,
+		key:         key,
+		msg_opts:    msg_opts,
+		msg_counter: 0,
+
+		skip_kubernetes_infra: skip_kubernetes_infra,
+		sanitizer:             clean,
+		overrides:             overrides,
+		filter:                filter,
+		dedup:                 dedup,
+		sampler:               sampler,
+		rate_limiter:          newRateLimiter(rate_limit, rate_burst, DEFAULT_RATE_SUMMARY_SECONDS*time.Second),
+		multiline:             multiline,
+		metrics:               metrics,
+		heartbeat:             beat,
+		stamper:               stamper,
+		log:                   logger,
 	}, nil
 }
 
 func (a *RedisAdapter) Stream(logstream chan *router.Message) {
 	conn := a.pool.Get()
-	defer conn.Close()
+	// the connection is replaced after push errors
+	defer func() { conn.Close() }()
 
-	mute := false
+	// failing is true from a failed push until the next successful one
+	failing := false
+	set_failing := func(f bool) {
+		failing = f
+		a.metrics.Mute(f, time.Now())
+	}
 
-	for m := range logstream {
-		a.msg_counter += 1
-		msg_id := fmt.Sprintf("%s#%d", m.Container.ID[0:12], a.msg_counter)
+	// logspout sets the id of command line routes after creating the adapter
+	a.metrics.route = a.route.ID
+	a.log.route = a.route.ID
+	a.metrics.queue = func() int {
+		held := a.multiline.Buffered()
+		if a.dedup != nil {
+			held += a.dedup.Held()
+		}
+		return held
+	}
+	registerMetrics(a.metrics)
+	defer unregisterMetrics(a.metrics)
 
-		js, err := createLogstashMessage(m, a.docker_host, a.use_v0, a.logstash_type, a.dedot_labels)
-		if err != nil {
-			if a.mute_errors {
-				if !mute {
-					log.Printf("redis[%s]: error on json.Marshal (muting until recovered): %s\n", msg_id, err)
-					mute = true
+	if a.sanitizer != nil {
+		logstream = a.sanitizer.Process(logstream)
+	}
+	logstream = a.multiline.Process(logstream)
+	if a.msg_opts.json_arrays == JSON_ARRAYS_SPLIT {
+		logstream = splitJSONArrays(logstream, a.msg_opts.json_prefix, a.overrides)
+	}
+
+	push := func(msg_id string, key string, events [][]byte) {
+		for _, js := range events {
+			start := time.Now()
+			_, err := conn.Do("RPUSH", key, js)
+			if err != nil {
+				atomic.AddInt64(&a.metrics.push_errors, 1)
+				a.metrics.Failed(err, time.Now())
+				a.log.Errorf("rpush", "redis[%s]: error on rpush: %s\n", msg_id, err)
+				set_failing(true)
+
+				// first close old connection
+				conn.Close()
+
+				// next open new connection
+				conn = a.pool.Get()
+				atomic.AddInt64(&a.metrics.reconnects, 1)
+
+				// since message is already marshaled, send again
+				atomic.AddInt64(&a.metrics.retries, 1)
+				start = time.Now()
+				_, err = conn.Do("RPUSH", key, js)
+				if err != nil {
+					// the next log line is pushed on a new connection
+					conn.Close()
+					conn = a.pool.Get()
+					atomic.AddInt64(&a.metrics.push_errors, 1)
+					a.metrics.Failed(err, time.Now())
+					a.metrics.Drop(DROP_PUSH_FAILED, 1)
+					a.log.Errorf("rpush", "redis[%s]: error on rpush (retry): %s\n", msg_id, err)
+				} else {
+					a.metrics.Pushed(len(js), time.Since(start))
+					a.log.Resolved("rpush", "redis[%s]: successful retry rpush after error\n", msg_id)
+					set_failing(false)
 				}
+
+				continue
 			} else {
-				log.Printf("redis[%s]: error on json.Marshal: %s\n", msg_id, err)
+				a.metrics.Pushed(len(js), time.Since(start))
+				if failing {
+					a.log.Resolved("rpush", "redis[%s]: successful rpush after error\n", msg_id)
+					set_failing(false)
+				}
 			}
-			continue
 		}
-		_, err = conn.Do("RPUSH", a.key, js)
-		if err != nil {
-			if a.mute_errors {
-				if !mute {
-					log.Printf("redis[%s]: error on rpush (muting until restored): %s\n", msg_id, err)
-				}
-			} else {
-				log.Printf("redis[%s]: error on rpush: %s\n", msg_id, err)
+	}
+
+	marshal_error := func(msg_id string, err error) {
+		// a bad log line says nothing about the health of the route
+		atomic.AddInt64(&a.metrics.marshal_errors, 1)
+		a.log.Errorf("marshal", "redis[%s]: error on json.Marshal: %s\n", msg_id, err)
+	}
+
+	// ship samples, rate limits and pushes a log line, with repeat fields if
+	// it was deduplicated
+	ship := func(m *router.Message, repeat *RepeatFields) {
+		overrides := a.overrides.Get(m)
+		opts := overrides.opts
+		if a.sampler != nil || repeat != nil || a.stamper != nil {
+			copied := *overrides.opts
+			opts = &copied
+		}
+		if repeat != nil {
+			a.metrics.Drop(DROP_DEDUP, repeat.Count-1)
+		}
+		if a.sampler != nil {
+			keep, rate := a.sampler.Sample(m)
+			if !keep {
+				a.metrics.Drop(DROP_SAMPLED, 1)
+				return
 			}
-			mute = true
+			opts.sample_rate = rate
+		}
+		if a.rate_limiter != nil && !a.rate_limiter.Allow(m, time.Now()) {
+			a.metrics.Drop(DROP_RATE_LIMIT, 1)
+			return
+		}
+		opts.repeat = repeat
+		if a.stamper != nil {
+			opts.meta = a.stamper.Stamp(m, time.Now())
+		}
+
+		a.msg_counter += 1
+		msg_id := fmt.Sprintf("%s#%d", shortID(m.Container.ID), a.msg_counter)
 
-			// first close old connection
-			conn.Close()
+		events, err := createLogstashMessages(m, opts)
+		if err != nil {
+			marshal_error(msg_id, err)
+			return
+		}
+		push(msg_id, overrides.key, events)
+	}
 
-			// next open new connection
-			conn = a.pool.Get()
+	// adapter-generated events get an event id, but no sequence numbers
+	synthetic_meta := func(now time.Time) *MetaFields {
+		if a.stamper == nil {
+			return nil
+		}
+		return &MetaFields{ID: a.stamper.ID(now)}
+	}
 
-			// since message is already marshaled, send again
-			_, err = conn.Do("RPUSH", a.key, js)
+	ship_summaries := func(now time.Time) {
+		for _, summary := range a.rate_limiter.Summaries(now) {
+			a.msg_counter += 1
+			msg_id := fmt.Sprintf("%s#%d", shortID(summary.Container.ID), a.msg_counter)
+			overrides := a.overrides.Get(summary)
+			opts := *overrides.opts
+			opts.meta = synthetic_meta(now)
+			js, err := createSyntheticMessage(summary, &opts, TAG_RATE_LIMITED)
 			if err != nil {
-				conn.Close()
-				if !a.mute_errors {
-					log.Printf("redis[%s]: error on rpush (retry): %s\n", msg_id, err)
+				marshal_error(msg_id, err)
+				continue
+			}
+			push(msg_id, overrides.key, [][]byte{js})
+		}
+	}
+	var summaries <-chan time.Time
+	if a.rate_limiter != nil {
+		ticker := time.NewTicker(a.rate_limiter.interval)
+		defer ticker.Stop()
+		summaries = ticker.C
+	}
+	var dedup_flushes <-chan time.Time
+	if a.dedup != nil {
+		ticker := time.NewTicker(DEDUP_FLUSH_INTERVAL)
+		defer ticker.Stop()
+		dedup_flushes = ticker.C
+	}
+	save_sequences := func(now time.Time) {
+		if err := a.stamper.sequences.Save(now); err != nil {
+			a.log.Errorf("sequence_file", "redis: error saving sequences: %s\n", err)
+		}
+	}
+	var sequence_saves <-chan time.Time
+	if a.stamper != nil {
+		// also after the final flush of deduplicated log lines
+		defer func() { save_sequences(time.Now()) }()
+		ticker := time.NewTicker(SEQUENCE_SAVE_INTERVAL)
+		defer ticker.Stop()
+		sequence_saves = ticker.C
+	}
+	var heartbeats <-chan time.Time
+	if a.heartbeat != nil {
+		a.heartbeat.Refresh()
+		ticker := time.NewTicker(a.heartbeat.interval)
+		defer ticker.Stop()
+		heartbeats = ticker.C
+	}
+
+	for {
+		var m *router.Message
+		select {
+		case now := <-summaries:
+			ship_summaries(now)
+			continue
+		case now := <-sequence_saves:
+			save_sequences(now)
+			continue
+		case now := <-heartbeats:
+			a.msg_counter += 1
+			msg_id := fmt.Sprintf("%s#%d", LOGTYPE_HEARTBEAT, a.msg_counter)
+			opts := *a.msg_opts
+			opts.logtype = LOGTYPE_HEARTBEAT
+			opts.meta = synthetic_meta(now)
+			// the running containers are listed for the next heartbeat
+			running := a.heartbeat.Running(now)
+			a.heartbeat.Refresh()
+			js, err := createSyntheticMessage(a.heartbeat.Message(now, running), &opts)
+			if err != nil {
+				marshal_error(msg_id, err)
+				continue
+			}
+			push(msg_id, a.heartbeat.key, [][]byte{js})
+			continue
+		case now := <-dedup_flushes:
+			for _, entry := range a.dedup.Flush(now) {
+				ship(entry.m, entry.Repeat())
+			}
+			continue
+		case msg, ok := <-logstream:
+			if !ok {
+				if a.dedup != nil {
+					for _, entry := range a.dedup.FlushAll() {
+						ship(entry.m, entry.Repeat())
+					}
 				}
-			} else {
-				log.Printf("redis[%s]: successful retry rpush after error\n", msg_id)
-				mute = false
+				// report the log lines dropped since the last summaries
+				if a.rate_limiter != nil {
+					ship_summaries(time.Now())
+				}
+				return
 			}
+			m = msg
+		}
+		atomic.AddInt64(&a.metrics.messages_in, 1)
+		if a.heartbeat != nil {
+			a.heartbeat.Seen(m)
+		}
 
+		if a.skip_kubernetes_infra && isKubernetesInfraContainer(m.Container.Config.Labels) {
+			a.metrics.Drop(DROP_INFRA, 1)
 			continue
-		} else {
-			if mute {
-				log.Printf("redis[%s]: successful rpush after error\n", msg_id)
-				mute = false
+		}
+		if a.overrides.Get(m).exclude {
+			a.metrics.Drop(DROP_EXCLUDED, 1)
+			continue
+		}
+		if a.filter != nil && !a.filter.Ship(m) {
+			a.metrics.Drop(DROP_FILTERED, 1)
+			continue
+		}
+		if a.dedup != nil {
+			for _, entry := range a.dedup.Add(m, time.Now()) {
+				ship(entry.m, entry.Repeat())
 			}
+			continue
 		}
+		ship(m, nil)
 	}
 }
 
 func errorf(format string, a ...interface{}) (err error) {
 	err = fmt.Errorf(format, a...)
-	if os.Getenv("DEBUG") != "" {
-		fmt.Println(err.Error())
-	}
+	defaultLogger.Debugf("%s", err)
 	return
 }
 
@@ -234,14 +738,14 @@ func getintopt(options map[string]string, optkey string, envkey string, default_
 		var err error
 		value, err = strconv.Atoi(value_s)
 		if err != nil {
-			log.Printf("Invalid value for integer paramater %s: %s - using default: %d\n", optkey, value_s, default_value)
+			defaultLogger.Warnf("Invalid value for integer paramater %s: %s - using default: %d\n", optkey, value_s, default_value)
 			value = default_value
 		}
 	}
 	return
 }
 
-func newRedisConnectionPool(server, password string, database int, connect_timeout int, read_timeout int, write_timeout int) *redis.Pool {
+func newRedisConnectionPool(server, password string, database int, connect_timeout int, read_timeout int, write_timeout int, logger *leveledLogger) *redis.Pool {
 	return &redis.Pool{
 		MaxIdle:     1,
 		IdleTimeout: 240 * time.Second,
@@ -270,13 +774,21 @@ func newRedisConnectionPool(server, password string, database int, connect_timeo
 		TestOnBorrow: func(c redis.Conn, t time.Time) error {
 			_, err := c.Do("PING")
 			if err != nil {
-				log.Println("redis: test on borrow failed: ", err)
+				logger.Errorf("test_on_borrow", "redis: test on borrow failed: %s", err)
 			}
 			return err
 		},
 	}
 }
 
+// shortID returns the 12 character short form of a container id.
+func shortID(id string) string {
+	if len(id) > 12 {
+		return id[0:12]
+	}
+	return id
+}
+
 func splitImage(image_tag string) (image string, tag string) {
 	colon := strings.LastIndex(image_tag, ":")
 	sep := strings.LastIndex(image_tag, "/")
@@ -289,50 +801,134 @@ func splitImage(image_tag string) (image string, tag string) {
 	return
 }
 
+// dedotLabels returns a copy of labels with the dots in the names replaced
+// with underscores. The container labels are shared, so they are not modified.
 func dedotLabels(labels map[string]string) map[string]string {
-	for key, _ := range labels {
-		if strings.Contains(key, ".") {
-			dedotted_label := strings.Replace(key, ".", "_", -1)
-			labels[dedotted_label] = labels[key]
-			delete(labels, key)
-		}
+	dedotted := make(map[string]string, len(labels))
+	for key, value := range labels {
+		dedotted[strings.Replace(key, ".", "_", -1)] = value
+	}
+	return dedotted
+}
+
+func stringMap(m map[string]string) map[string]interface{} {
+	if m == nil {
+		return nil
+	}
+	converted := make(map[string]interface{}, len(m))
+	for key, value := range m {
+		converted[key] = value
 	}
+	return converted
+}
+
+func createLogstashMessage(m *router.Message, opts *messageOptions) ([]byte, error) {
+	return buildLogstashMessage(m, opts, nil)
+}
 
-	return labels
+// createSyntheticMessage creates the event for a log line generated by the
+// adapter itself. Its data is JSON, and the options to parse or modify log
+// lines are not applied.
+func createSyntheticMessage(m *router.Message, opts *messageOptions, tags ...string) ([]byte, error) {
+	synthetic := *opts
+	synthetic.timestamp_field = ""
+	synthetic.level_field = ""
+	synthetic.parse = ""
+	synthetic.grok_rules = nil
+	synthetic.json_prefix = nil
+	synthetic.json_arrays = ""
+	synthetic.max_message_bytes = 0
+	synthetic.max_fields = 0
+	synthetic.max_depth = 0
+	synthetic.redactor = nil
+	synthetic.tags = tags
+	return createLogstashMessage(m, &synthetic)
 }
 
-func createLogstashMessage(m *router.Message, docker_host string, use_v0 bool, logstash_type string, dedot_labels bool) ([]byte, error) {
+// buildLogstashMessage creates the event for a message, or for a chunk of a
+// message when chunk is set.
+func buildLogstashMessage(m *router.Message, opts *messageOptions, chunk *ChunkFields) ([]byte, error) {
+	original_length := len(m.Data)
+	// repair invalid UTF-8 ourselves, so the event can be tagged
+	data, invalid_utf8 := repairUTF8(m.Data)
+	if invalid_utf8 {
+		repaired := *m
+		repaired.Data = data
+		m = &repaired
+	}
+
 	image, image_tag := splitImage(m.Container.Config.Image)
-	cid := m.Container.ID[0:12]
-	name := m.Container.Name[1:]
+	cid := shortID(m.Container.ID)
+	name := strings.TrimPrefix(m.Container.Name, "/")
+	if opts.swarm {
+		name = swarmContainerName(name, m.Container.Config.Labels)
+	}
 	timestamp := m.Time.UTC().Format(time.RFC3339Nano)
 
-	if use_v0 {
+	labels := m.Container.Config.Labels
+	if len(opts.include_labels) > 0 || len(opts.exclude_labels) > 0 || len(opts.strip_label_prefixes) > 0 {
+		labels = filterLabels(labels, opts.include_labels, opts.exclude_labels, opts.strip_label_prefixes)
+	}
+	// see https://github.com/rtoma/logspout-redis-logstash/issues/11
+	var docker_labels map[string]interface{}
+	if opts.nest_labels {
+		docker_labels = nestLabels(labels)
+	} else if opts.dedot_labels {
+		docker_labels = stringMap(dedotLabels(labels))
+	} else {
+		docker_labels = stringMap(labels)
+	}
+	env := filterEnv(m.Container.Config.Env, opts.include_env)
+
+	// oversized messages are truncated, and never parsed. They are redacted
+	// before truncation, so a value cut in half is not missed. Chunks are
+	// split to size already.
+	data = m.Data
+	truncated := chunk == nil && opts.max_message_bytes > 0 && len(data) > opts.max_message_bytes
+	if truncated {
+		if opts.redactor != nil {
+			data = opts.redactor.Redact(data)
+		}
+		data = truncateUTF8(data, opts.max_message_bytes)
+	}
+
+	if opts.use_v0 {
 		msg := LogstashMessageV0{}
 
-		msg.Type = logstash_type
+		msg.Type = opts.logstash_type
 		msg.Timestamp = timestamp
-		msg.Message = m.Data
+		msg.Message = data
 		msg.Sourcehost = m.Container.Config.Hostname
 		msg.Fields.Docker.CID = cid
 		msg.Fields.Docker.Name = name
 		msg.Fields.Docker.Image = image
 		msg.Fields.Docker.ImageTag = image_tag
 		msg.Fields.Docker.Source = m.Source
-		msg.Fields.Docker.DockerHost = docker_host
-
-		// see https://github.com/rtoma/logspout-redis-logstash/issues/11
-		if dedot_labels {
-			msg.Fields.Docker.Labels = dedotLabels(m.Container.Config.Labels)
-		} else {
-			msg.Fields.Docker.Labels = m.Container.Config.Labels
+		msg.Fields.Docker.DockerHost = opts.docker_host
+		msg.Fields.Docker.Labels = docker_labels
+		msg.Fields.Docker.Env = env
+		if truncated {
+			msg.Fields.Truncated = true
+			msg.Fields.OriginalLength = original_length
+		}
+		msg.Fields.Chunk = chunk
+		msg.Fields.SampleRate = opts.sample_rate
+		msg.Fields.RepeatFields = opts.repeat
+		msg.Fields.MetaFields = opts.meta
+		msg.Fields.Logtype = opts.logtype
+		msg.Fields.Tags = append(msg.Fields.Tags, opts.tags...)
+		if invalid_utf8 {
+			msg.Fields.Tags = append(msg.Fields.Tags, TAG_INVALID_UTF8)
+		}
+		if opts.redactor != nil && !truncated {
+			msg.Message = opts.redactor.Redact(msg.Message)
 		}
 
 		return json.Marshal(msg)
 	} else {
 		msg := LogstashMessageV1{}
 
-		msg.Type = logstash_type
+		msg.Type = opts.logstash_type
 		msg.Timestamp = timestamp
 		msg.Sourcehost = m.Container.Config.Hostname
 		msg.Fields.CID = cid
@@ -340,29 +936,105 @@ func createLogstashMessage(m *router.Message, docker_host string, use_v0 bool, l
 		msg.Fields.Image = image
 		msg.Fields.ImageTag = image_tag
 		msg.Fields.Source = m.Source
-		msg.Fields.DockerHost = docker_host
+		msg.Fields.DockerHost = opts.docker_host
+		msg.Fields.Labels = docker_labels
+		msg.Fields.Env = env
 
-		// see https://github.com/rtoma/logspout-redis-logstash/issues/11
-		if dedot_labels {
-			msg.Fields.Labels = dedotLabels(m.Container.Config.Labels)
-		} else {
-			msg.Fields.Labels = m.Container.Config.Labels
+		if opts.kubernetes {
+			msg.Kubernetes = kubernetesFields(m.Container.Config.Labels)
+		}
+		if opts.compose {
+			msg.Compose = composeFields(m.Container.Config.Labels)
+		}
+		if opts.swarm {
+			msg.Swarm = swarmFields(m.Container.Config.Labels)
+		}
+
+		msg.SampleRate = opts.sample_rate
+		msg.RepeatFields = opts.repeat
+		msg.MetaFields = opts.meta
+		msg.Tags = append(msg.Tags, opts.tags...)
+		if invalid_utf8 {
+			msg.Tags = append(msg.Tags, TAG_INVALID_UTF8)
+		}
+
+		logtypes := opts.logtypes
+		if logtypes == nil {
+			logtypes = defaultLogtypes
 		}
 
 		// Check if the message to log itself is json
-		if validJsonMessage(strings.TrimSpace(m.Data)) {
+		parsed := false
+		js, prefix_fields, is_json := findJSON(m.Data, opts.json_prefix)
+		if truncated || chunk != nil {
+			msg.Message = data
+			msg.Truncated = truncated
+			if truncated {
+				msg.OriginalLength = original_length
+			}
+			msg.Chunk = chunk
+		} else if is_json && validJsonMessage(js) {
 			// So it is, include it in the LogstashmessageV1
-			err := msg.UnmarshalDynamicJSON([]byte(m.Data))
+			err := msg.UnmarshalDynamicJSON([]byte(js), logtypes)
 			if err != nil {
 				// Can't unmarshall the json (invalid?), put it in message
 				msg.Message = m.Data
-			} else if msg.Message == "" {
-				msg.Message = NO_MESSAGE_PROVIDED
+			} else {
+				msg.addFields(prefix_fields)
+				parsed = true
+			}
+		} else if is_json && opts.json_arrays == JSON_ARRAYS_FIELD {
+			var elements []interface{}
+			if err := json.Unmarshal([]byte(js), &elements); err != nil {
+				msg.Message = m.Data
+			} else {
+				fields := map[string]interface{}{opts.json_array_field: elements}
+				msg.setDynamicFields(fields, logtypes)
+				msg.addFields(prefix_fields)
+				parsed = true
 			}
+		} else if rule := opts.grok_rules.Select(m); rule != nil {
+			if fields, ok := rule.Match(m.Data); ok {
+				msg.setDynamicFields(fields, logtypes)
+				if msg.Message == "" {
+					msg.Message = m.Data
+				}
+				parsed = true
+			} else {
+				msg.Message = m.Data
+				msg.Tags = append(msg.Tags, TAG_PARSE_FAILURE)
+			}
+		} else if fields, ok := parseMessage(m.Data, opts.parse); ok {
+			msg.setDynamicFields(fields, logtypes)
+			parsed = true
 		} else {
 			// Regular logging (no json)
 			msg.Message = m.Data
 		}
+
+		if msg.Logtype == "" && opts.logtype != "" {
+			msg.Logtype = opts.logtype
+		}
+		if opts.redactor != nil && !truncated {
+			msg.Message = opts.redactor.Redact(msg.Message)
+			opts.redactor.RedactFields(msg.LogtypeFields)
+		}
+
+		if parsed {
+			if msg.Message == "" {
+				msg.Message = NO_MESSAGE_PROVIDED
+			}
+			if opts.max_fields > 0 || opts.max_depth > 0 {
+				msg.DroppedFields = capFields(msg.LogtypeFields, opts.max_fields, opts.max_depth)
+				msg.Truncated = msg.DroppedFields > 0
+			}
+			if opts.timestamp_field != "" && opts.timestamp_parser != nil {
+				msg.promoteTimestamp(opts.timestamp_field, opts.timestamp_parser)
+			}
+			if opts.level_field != "" {
+				msg.promoteLevel(opts.level_field)
+			}
+		}
 		return json.Marshal(msg)
 	}
 
@@ -376,7 +1048,7 @@ func validJsonMessage(s string) bool {
 	return true
 }
 
-func (d *LogstashMessageV1) UnmarshalDynamicJSON(data []byte) error {
+func (d *LogstashMessageV1) UnmarshalDynamicJSON(data []byte, logtypes *logtypeRegistry) error {
 	var dynMap map[string]interface{}
 
 	if d == nil {
@@ -387,38 +1059,65 @@ func (d *LogstashMessageV1) UnmarshalDynamicJSON(data []byte) error {
 		return err
 	}
 
-	// Take logtype of the hash, but only if it is a valid logtype
-	if _, ok := dynMap["logtype"].(string); ok {
-		if dynMap["logtype"].(string) == LOGTYPE_APPLICATIONLOG || dynMap["logtype"].(string) == LOGTYPE_ACCESSLOG {
-			d.Logtype = dynMap["logtype"].(string)
-			delete(dynMap, "logtype")
-		}
+	d.setDynamicFields(dynMap, logtypes)
+	return nil
+}
+
+// setDynamicFields sets the logtype, message and logtype fields from the fields
+// of a parsed (json or other format) log line.
+func (d *LogstashMessageV1) setDynamicFields(dynMap map[string]interface{}, logtypes *logtypeRegistry) {
+	// Take logtype of the hash, but only if it is a known logtype
+	if logtype, ok := dynMap[logtypes.field].(string); ok && logtypes.Known(logtype) {
+		d.Logtype = logtype
+		delete(dynMap, logtypes.field)
 	}
-	// Take message out of the hash
-	if _, ok := dynMap["message"]; ok {
-		d.Message = dynMap["message"].(string)
+	// Take message out of the hash, objects and arrays are left in the field map
+	if message, ok := messageString(dynMap["message"]); ok {
+		d.Message = message
 		delete(dynMap, "message")
 	}
 
-	// Only initialize the "used" hash in struct
-	if d.Logtype == LOGTYPE_APPLICATIONLOG {
-		d.LogtypeAppfields = make(map[string]interface{}, 0)
-	} else if d.Logtype == LOGTYPE_ACCESSLOG {
-		d.LogtypeAccessfields = make(map[string]interface{}, 0)
-	} else {
-		d.LogtypeEventfields = make(map[string]interface{}, 0)
-	}
+	// The remaining fields end up in a hash named after the logtype
+	d.LogtypeFields = dynMap
+}
 
-	// Fill the right hash based on logtype
-	for key, val := range dynMap {
-		if d.Logtype == LOGTYPE_APPLICATIONLOG {
-			d.LogtypeAppfields[key] = val
-		} else if d.Logtype == LOGTYPE_ACCESSLOG {
-			d.LogtypeAccessfields[key] = val
-		} else {
-			d.LogtypeEventfields[key] = val
+// addFields adds fields to the logtype fields, without overwriting existing ones.
+func (d *LogstashMessageV1) addFields(fields map[string]interface{}) {
+	if len(fields) == 0 {
+		return
+	}
+	if d.LogtypeFields == nil {
+		d.LogtypeFields = make(map[string]interface{}, len(fields))
+	}
+	for key, value := range fields {
+		if _, ok := d.LogtypeFields[key]; !ok {
+			d.LogtypeFields[key] = value
 		}
 	}
+}
 
-	return nil
+// parseMessage parses a plain text log line in the configured format.
+func parseMessage(data string, format string) (map[string]interface{}, bool) {
+	switch format {
+	case PARSE_LOGFMT:
+		return parseLogfmt(data)
+	}
+	return nil, false
+}
+
+// messageString converts the message value of an embedded JSON document to a
+// string. Returns false for values (objects, arrays) that cannot be represented
+// as a plain message.
+func messageString(value interface{}) (string, bool) {
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	case nil:
+		return "", true
+	}
+	return "", false
 }
@@ -3,11 +3,18 @@ package redis
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
+	"io/ioutil"
 	//"log"
+	"regexp"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"testing/quick"
 	"time"
 
 	"github.com/fsouza/go-dockerclient"
+	"github.com/garyburd/redigo/redis"
 	"github.com/gliderlabs/logspout/router"
 	"github.com/jmoiron/jsonq"
 	"github.com/stretchr/testify/assert"
@@ -61,7 +68,7 @@ func TestCreateLogstashMessageV1(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 
 	assert.Equal("my-type", getString(jq, "@type"))
@@ -98,7 +105,7 @@ func TestCreateLogstashMessageV0(t *testing.T) {
 		Time:   time.Unix(int64(1453813310), 1000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", true, "some-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", use_v0: true, logstash_type: "some-type"})
 	jq := makeQuery(msg)
 
 	assert.Equal("some-type", getString(jq, "@type"))
@@ -135,7 +142,7 @@ func TestCreateLogstashMessageOptionalType(t *testing.T) {
 		Time:   time.Unix(int64(1453813330), 0),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", true, "", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", use_v0: true})
 	jq := makeQuery(msg)
 	//log.Printf("Standard message: %s", msg)
 
@@ -161,7 +168,7 @@ func TestCreateLogstashMessageWithJsonData(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 
 	assert.Equal("something happened", getString(jq, "message"))
@@ -190,7 +197,7 @@ func TestCreateLogstashMessageWithJsonDataAndNoMessage(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 
 	assert.Equal("no message", getString(jq, "message"))
@@ -215,7 +222,7 @@ func TestCreateLogstashMessageWithJsonDataAndNoLogtype(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 
 	assert.Equal("here i am!", getString(jq, "message"))
@@ -244,7 +251,7 @@ func TestCreateLogstashMessageWithJsonDataAndUnknownLogtype(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 	//log.Printf("Dynamic message: %s", msg)
 
@@ -275,7 +282,7 @@ func TestCreateLogstashMessageWithJsonDataAndAccesLogtype(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 	//log.Printf("Dynamic message: %s", msg)
 
@@ -304,7 +311,7 @@ func TestCreateLogstashMessageWithJsonDataAndNumericLogtype(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 	//log.Printf("Dynamic message: %s", msg)
 
@@ -331,7 +338,7 @@ func TestCreateLogstashMessageWithInvalidJsonData(t *testing.T) {
 		Time:   time.Unix(int64(1453818496), 595000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "my-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "my-type"})
 	jq := makeQuery(msg)
 	//log.Printf("Dynamic message invalid json: %s", msg)
 
@@ -358,7 +365,7 @@ func TestCreateLogstashMessageV0WithDeDottingEnabled(t *testing.T) {
 		Time:   time.Unix(int64(1453813310), 1000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", true, "some-type", true)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", use_v0: true, logstash_type: "some-type", dedot_labels: true})
 	jq := makeQuery(msg)
 	//log.Printf("%s", msg)
 
@@ -386,7 +393,7 @@ func TestCreateLogstashMessageV1WithDeDottingEnabled(t *testing.T) {
 		Time:   time.Unix(int64(1453813310), 1000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "some-type", true)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "some-type", dedot_labels: true})
 	jq := makeQuery(msg)
 
 	assert.Equal("abc", getString(jq, "docker", "labels", "label_1_2_3"))
@@ -413,7 +420,7 @@ func TestCreateLogstashMessageV0WithDeDottingDefaultDisabled(t *testing.T) {
 		Time:   time.Unix(int64(1453813310), 1000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", true, "some-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", use_v0: true, logstash_type: "some-type"})
 	jq := makeQuery(msg)
 
 	assert.Equal("abc", getString(jq, "@fields", "docker", "labels", "label.1.2.3"))
@@ -440,7 +447,7 @@ func TestCreateLogstashMessageV1WithDeDottingDefaultDisabled(t *testing.T) {
 		Time:   time.Unix(int64(1453813310), 1000000),
 	}
 
-	msg, _ := createLogstashMessage(&m, "tst-mesos-slave-001", false, "some-type", false)
+	msg, _ := createLogstashMessage(&m, &messageOptions{docker_host: "tst-mesos-slave-001", logstash_type: "some-type"})
 	jq := makeQuery(msg)
     //log.Printf("%s", msg)
 
@@ -465,11 +472,207 @@ func TestValidJsonMessageJson(t *testing.T) {
 
 }
 
+func TestCreateLogstashMessageWithJsonDataAndNonStringMessage(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/my_app",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:1234",
+			},
+		},
+		Source: "stdout",
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	m.Data = `{"message": 42, "level": "INFO"}`
+	msg, err := createLogstashMessage(&m, &messageOptions{})
+	assert.Nil(err)
+	jq := makeQuery(msg)
+	assert.Equal("42", getString(jq, "message"))
+	assert.Equal("INFO", getString(jq, "event", "level"))
+
+	m.Data = `{"message": 1.5e3}`
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	assert.Equal("1500", getString(makeQuery(msg), "message"))
+
+	m.Data = `{"message": true}`
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	assert.Equal("true", getString(makeQuery(msg), "message"))
+
+	m.Data = `{"message": null, "level": "INFO"}`
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+	assert.Equal("no message", getString(jq, "message"))
+	_, err = jq.Interface("event", "message")
+	assert.NotNil(err)
+
+	m.Data = `{"message": {"a": 1}, "level": "INFO"}`
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+	assert.Equal("no message", getString(jq, "message"))
+	assert.Equal(1, getInt(jq, "event", "message", "a"))
+
+	m.Data = `{"logtype": "applog", "message": ["a", "b"]}`
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+	assert.Equal("no message", getString(jq, "message"))
+	arr, _ := jq.ArrayOfStrings("applog", "message")
+	assert.Equal([]string{"a", "b"}, arr)
+
+}
+
+func TestCreateLogstashMessageWithShortContainerIdAndName(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:     "6fef",
+			Name:   "",
+			Config: &docker.Config{},
+		},
+		Data: "hello world",
+	}
+
+	msg, err := createLogstashMessage(&m, &messageOptions{})
+	assert.Nil(err)
+	jq := makeQuery(msg)
+	assert.Equal("6fef", getString(jq, "docker", "cid"))
+	assert.Equal("", getString(jq, "docker", "name"))
+
+}
+
+// fuzzJsonSeeds are mutated by TestCreateLogstashMessageFuzzJson to generate
+// (mostly) JSON-like input.
+var fuzzJsonSeeds = []string{
+	`{"message":"hello"}`,
+	`{"logtype":"applog","message":"hi","level":"DEBUG","line":42}`,
+	`{"logtype":"accesslog","message":{"a":[1,2,{"b":null}]}}`,
+	`{"message":42,"logtype":1}`,
+	`{"message":"x","time":"2016-10-16T10:00:00.123Z","level":"warn"}`,
+	`{"message":"x","time":1476612000123,"level":40}`,
+	`{"message":null,"logtype":null}`,
+	`{"message":[true,false],"nested":{"deep":{"deeper":{}}}}`,
+	`{"message":"\u00e9\ud83d\ude00","x":1e308}`,
+	`{}`,
+	`[{"message":"a"},"b",[1]]`,
+	"\ufeff{\"message\":\"bom\"}\r",
+}
+
+func fuzzOptions() []*messageOptions {
+	grok, _ := compileGrok(`%{WORD:logtype} %{NUMBER:message:float} %{GREEDYDATA:rest}`, nil)
+	return []*messageOptions{
+		{},
+		{use_v0: true, dedot_labels: true},
+		{
+			nest_labels:          true,
+			include_labels:       newGlobList("*"),
+			exclude_labels:       newGlobList("a*"),
+			strip_label_prefixes: []string{"com.", "."},
+			include_env:          newGlobList("*"),
+			kubernetes:           true,
+			compose:              true,
+			swarm:                true,
+			timestamp_field:      "time",
+			timestamp_parser:     newTimestampParser("rfc3339|epoch|epoch_millis|2006-01-02"),
+			level_field:          "level",
+			parse:                PARSE_LOGFMT,
+		},
+		{grok_rules: grokRules{&grokRule{patterns: []*grokPattern{grok}}}},
+		{json_prefix: regexp.MustCompile(`^(?P<a>.)(?P<b>x)?`), json_arrays: JSON_ARRAYS_FIELD, json_array_field: "items"},
+	}
+}
+
+func createLogstashMessagePanics(m *router.Message) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			panicked = true
+		}
+	}()
+	for _, opts := range fuzzOptions() {
+		createLogstashMessage(m, opts)
+	}
+	return false
+}
+
+func TestCreateLogstashMessageFuzz(t *testing.T) {
+	f := func(id, name, image, source, data string, env []string, labels map[string]string) bool {
+		m := router.Message{
+			Container: &docker.Container{
+				ID:   id,
+				Name: name,
+				Config: &docker.Config{
+					Image:  image,
+					Env:    env,
+					Labels: labels,
+				},
+			},
+			Source: source,
+			Data:   data,
+		}
+		if createLogstashMessagePanics(&m) {
+			t.Logf("panic on id: %q, name: %q, image: %q, data: %q, env: %q, labels: %q", id, name, image, data, env, labels)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 2000}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateLogstashMessageFuzzJson(t *testing.T) {
+	f := func(seed uint8, positions []uint16, replacements []byte) bool {
+		data := []byte(fuzzJsonSeeds[int(seed)%len(fuzzJsonSeeds)])
+		for i, pos := range positions {
+			if i < len(replacements) {
+				data[int(pos)%len(data)] = replacements[i]
+			}
+		}
+		m := router.Message{
+			Container: &docker.Container{
+				ID:     "6feffd9428dc",
+				Name:   "/my_app",
+				Config: &docker.Config{Image: "image:tag"},
+			},
+			Data: string(data),
+		}
+		if createLogstashMessagePanics(&m) {
+			t.Logf("panic on data: %q", data)
+			return false
+		}
+		return true
+	}
+	if err := quick.Check(f, &quick.Config{MaxCount: 5000}); err != nil {
+		t.Error(err)
+	}
+
+	for _, seed := range fuzzJsonSeeds {
+		m := router.Message{
+			Container: &docker.Container{ID: "6feffd9428dc", Name: "/my_app", Config: &docker.Config{}},
+			Data:      seed,
+		}
+		if createLogstashMessagePanics(&m) {
+			t.Errorf("panic on seed: %q", seed)
+		}
+	}
+}
+
 func getInt(j *jsonq.JsonQuery, s ...string) int {
 	v, _ := j.Int(s...)
 	return v
 }
 
+func getFloat(j *jsonq.JsonQuery, s ...string) float64 {
+	v, _ := j.Float(s...)
+	return v
+}
+
 func getString(j *jsonq.JsonQuery, s ...string) string {
 	v, _ := j.String(s...)
 	return v
@@ -482,3 +685,204 @@ func makeQuery(msg []byte) *jsonq.JsonQuery {
 	jq := jsonq.NewQuery(data)
 	return jq
 }
+
+// testMessage returns a log line of the container with the given id, image
+// app:1 and a copy of labels.
+func testMessage(id string, source string, data string, labels map[string]string) *router.Message {
+	var copied map[string]string
+	if labels != nil {
+		copied = make(map[string]string, len(labels))
+		for key, value := range labels {
+			copied[key] = value
+		}
+	}
+	return &router.Message{
+		Container: &docker.Container{
+			ID:     id,
+			Name:   "/" + id,
+			Config: &docker.Config{Hostname: id, Image: "app:1", Labels: copied},
+		},
+		Source: source,
+		Data:   data,
+		Time:   time.Unix(int64(1453813310), 1000000),
+	}
+}
+
+// fakeRedis records the events pushed to it by key. Pushes of events holding
+// one of the strings in failures fail that many times.
+type fakeRedis struct {
+	mu       sync.Mutex
+	pushed   map[string][][]byte
+	failures map[string]int
+}
+
+func (f *fakeRedis) Pool() *redis.Pool {
+	return &redis.Pool{Dial: func() (redis.Conn, error) { return &fakeConn{f}, nil }}
+}
+
+// Pushed returns the events pushed to key.
+func (f *fakeRedis) Pushed(key string) []*jsonq.JsonQuery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var events []*jsonq.JsonQuery
+	for _, js := range f.pushed[key] {
+		events = append(events, makeQuery(js))
+	}
+	return events
+}
+
+type fakeConn struct {
+	redis *fakeRedis
+}
+
+func (c *fakeConn) Do(command string, args ...interface{}) (interface{}, error) {
+	if command != "RPUSH" {
+		return nil, nil
+	}
+	f := c.redis
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key, js := args[0].(string), args[1].([]byte)
+	for s, n := range f.failures {
+		if n > 0 && bytes.Contains(js, []byte(s)) {
+			f.failures[s]--
+			return nil, errors.New("connection reset by peer")
+		}
+	}
+	if f.pushed == nil {
+		f.pushed = make(map[string][][]byte)
+	}
+	f.pushed[key] = append(f.pushed[key], js)
+	return int64(len(f.pushed[key])), nil
+}
+
+func (c *fakeConn) Close() error                                    { return nil }
+func (c *fakeConn) Err() error                                      { return nil }
+func (c *fakeConn) Send(command string, args ...interface{}) error { return nil }
+func (c *fakeConn) Flush() error                                    { return nil }
+func (c *fakeConn) Receive() (interface{}, error)                   { return nil, nil }
+
+// newTestAdapter returns an adapter pushing to f, with the stages set up like
+// NewRedisAdapter does. Stages are added by setting their fields.
+func newTestAdapter(f *fakeRedis, opts *messageOptions) *RedisAdapter {
+	logger, _ := newLeveledLogger("error", LOG_FORMAT_TEXT, 0, "")
+	logger.out = ioutil.Discard
+	overrides := newOverrideCache("logspout", opts)
+	overrides.log = logger
+	multiline := newMultilineAggregator(nil, nil, DEFAULT_MULTILINE_MAX_LINES, DEFAULT_MULTILINE_MAX_BYTES, time.Minute)
+	multiline.overrides = overrides
+	return &RedisAdapter{
+		route:     &router.Route{ID: "r1"},
+		pool:      f.Pool(),
+		key:       "logspout",
+		msg_opts:  opts,
+		overrides: overrides,
+		multiline: multiline,
+		metrics:   newAdapterMetrics("", "redis:6379"),
+		log:       logger,
+	}
+}
+
+// stream sends messages to the adapter, and returns when Stream returns after
+// the log stream is closed.
+func stream(a *RedisAdapter, messages ...*router.Message) {
+	logstream := make(chan *router.Message)
+	go func() {
+		for _, m := range messages {
+			logstream <- m
+		}
+		close(logstream)
+	}()
+	a.Stream(logstream)
+}
+
+func TestStreamPipeline(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &fakeRedis{}
+	a := newTestAdapter(f, &messageOptions{json_arrays: JSON_ARRAYS_SPLIT})
+	a.sanitizer = &sanitizer{strip_control: true}
+	a.multiline.start = regexp.MustCompile(`^\S`)
+	a.filter, _ = newMessageFilter("drop message=health", "", FILTER_KEEP, nil)
+	sequences, _ := openSequenceStore("")
+	a.stamper = &eventStamper{ids: EVENT_ID_UUID, route: "r1", sequences: sequences, log: a.log}
+
+	stream(a,
+		testMessage("web", "stdout", "\x07start of trace", nil),
+		testMessage("web", "stdout", "  at frame 1", nil),
+		testMessage("web", "stdout", `["a","b"]`, nil),
+		testMessage("web", "stdout", "GET /health", nil),
+		testMessage("web", "stdout", "done", nil),
+	)
+
+	// lines are cleaned before they are joined, and arrays are split after
+	events := f.Pushed("logspout")
+	var messages []string
+	for i, event := range events {
+		messages = append(messages, getString(event, "message"))
+		assert.Equal(i+1, getInt(event, "sequence"))
+	}
+	assert.Equal([]string{"start of trace\n  at frame 1", "a", "b", "done"}, messages)
+	assert.Equal(int64(5), a.metrics.messages_in)
+	assert.Equal(int64(1), atomic.LoadInt64(a.metrics.dropped[DROP_FILTERED]))
+}
+
+func TestStreamFlushOnClose(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &fakeRedis{}
+	a := newTestAdapter(f, &messageOptions{})
+	a.dedup = newDeduplicator(time.Minute, false, DEFAULT_DEDUP_MAX_ENTRIES)
+	a.rate_limiter = newRateLimiter(1, 1, time.Minute)
+	sequences, _ := openSequenceStore("")
+	a.stamper = &eventStamper{ids: EVENT_ID_ULID, route: "r1", sequences: sequences, log: a.log}
+
+	stream(a,
+		testMessage("web", "stdout", "same", nil),
+		testMessage("web", "stdout", "same", nil),
+		testMessage("web", "stdout", "same", nil),
+		testMessage("db", "stdout", "x1", nil),
+		testMessage("db", "stdout", "x2", nil),
+		testMessage("db", "stdout", "x3", nil),
+	)
+
+	// held log lines are shipped, and the drops since the last summary are
+	// reported
+	events := f.Pushed("logspout")
+	assert.Len(events, 3)
+	assert.Equal("same", getString(events[0], "message"))
+	assert.Equal(3, getInt(events[0], "repeat_count"))
+	assert.Equal("x1", getString(events[1], "message"))
+	assert.Equal("dropped 2 messages from db in the last 1m0s", getString(events[2], "message"))
+	tags, _ := events[2].ArrayOfStrings("tags")
+	assert.Equal([]string{TAG_RATE_LIMITED}, tags)
+	// summaries have an event id, but are not counted in the sequences
+	assert.Len(getString(events[2], "event_id"), 26)
+	_, err := events[2].Int("sequence")
+	assert.NotNil(err)
+	assert.Equal(2, getInt(events[1], "adapter_sequence"))
+	assert.Equal(0, a.dedup.Held())
+}
+
+func TestStreamRetry(t *testing.T) {
+	assert := assert.New(t)
+
+	f := &fakeRedis{failures: map[string]int{`"one"`: 1, `"two"`: 2}}
+	a := newTestAdapter(f, &messageOptions{})
+
+	stream(a,
+		testMessage("web", "stdout", "one", nil),
+		testMessage("web", "stdout", "two", nil),
+		testMessage("web", "stdout", "three", nil),
+	)
+
+	// a failed push is retried once on a new connection
+	events := f.Pushed("logspout")
+	assert.Len(events, 2)
+	assert.Equal("one", getString(events[0], "message"))
+	assert.Equal("three", getString(events[1], "message"))
+	assert.Equal(int64(2), a.metrics.retries)
+	assert.Equal(int64(3), a.metrics.push_errors)
+	assert.Equal(int64(1), atomic.LoadInt64(a.metrics.dropped[DROP_PUSH_FAILED]))
+	assert.False(a.metrics.Status(time.Now()).Muted)
+}
@@ -0,0 +1,130 @@
+package redis
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const DEFAULT_SAMPLE_LEVEL_FIELD = "level"
+
+// sampleRule ships a fraction (rate) of the log lines it matches. With a key,
+// the decision is based on the hash of that embedded JSON field, so log lines
+// with the same value (e.g. a trace id) are kept or dropped together.
+type sampleRule struct {
+	messageMatcher
+	rate   float64
+	levels []string
+	key    string
+}
+
+func (r *sampleRule) Match(m *router.Message, fields func() map[string]interface{}, level_field string) bool {
+	if !r.Selects(m) {
+		return false
+	}
+	if len(r.levels) > 0 {
+		level, ok := normalizeLevel(lookupField(fields(), level_field))
+		if !ok {
+			return false
+		}
+		for _, l := range r.levels {
+			if l == level {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// sampler decides which log lines are shipped, using the first matching rule.
+// Log lines not matching any rule are always shipped.
+type sampler struct {
+	rules       []*sampleRule
+	level_field string
+	json_prefix *regexp.Regexp
+	random      func() float64
+}
+
+// Sample returns whether m should be shipped, and the sample rate it was
+// shipped with.
+func (s *sampler) Sample(m *router.Message) (bool, float64) {
+	get_fields := lazyFields(m.Data, s.json_prefix)
+
+	for _, rule := range s.rules {
+		if !rule.Match(m, get_fields, s.level_field) {
+			continue
+		}
+		if rule.key != "" {
+			if value, ok := messageString(lookupField(get_fields(), rule.key)); ok && value != "" {
+				return hashFraction(value) < rule.rate, rule.rate
+			}
+		}
+		return s.random() < rule.rate, rule.rate
+	}
+	return true, 1
+}
+
+// hashFraction maps value to a number in [0, 1). The FNV hash is mixed (as in
+// the MurmurHash3 finalizer), as its high bits vary little for similar values.
+func hashFraction(value string) float64 {
+	h := fnv.New64a()
+	h.Write([]byte(value))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return float64(x>>11) / (1 << 53)
+}
+
+// newSampler parses the sample option: rules separated by ';', each a rate
+// followed by conditions, e.g. '0.1 level=info,debug key=trace_id'.
+func newSampler(option string, level_field string, json_prefix *regexp.Regexp) (*sampler, error) {
+	s := &sampler{level_field: level_field, json_prefix: json_prefix, random: rand.Float64}
+	for _, spec := range strings.Split(option, ";") {
+		tokens := strings.Fields(spec)
+		if len(tokens) == 0 {
+			continue
+		}
+
+		rate, err := strconv.ParseFloat(tokens[0], 64)
+		if err != nil || math.IsNaN(rate) || rate < 0 || rate > 1 {
+			return nil, fmt.Errorf("invalid sample rule '%s', rate must be between 0 and 1", spec)
+		}
+		rule := &sampleRule{rate: rate}
+		for _, token := range tokens[1:] {
+			condition := strings.SplitN(token, "=", 2)
+			if len(condition) != 2 {
+				return nil, fmt.Errorf("invalid sample rule '%s', expected <condition>=<value>", spec)
+			}
+			switch value := condition[1]; condition[0] {
+			case "level":
+				for _, name := range splitList(value) {
+					level, ok := normalizeLevel(name)
+					if !ok {
+						return nil, fmt.Errorf("invalid sample rule '%s', unknown level %s", spec, name)
+					}
+					rule.levels = append(rule.levels, level)
+				}
+			case "source":
+				rule.source = value
+			case "label":
+				rule.setLabel(value)
+			case "key":
+				rule.key = value
+			default:
+				return nil, fmt.Errorf("invalid sample rule '%s', unknown condition %s", spec, condition[0])
+			}
+		}
+		s.rules = append(s.rules, rule)
+	}
+	return s, nil
+}
@@ -0,0 +1,105 @@
+package redis
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// webTier holds the labels of the container sampled in the tests
+var webTier = map[string]string{"com.example.tier": "web"}
+
+func TestSamplerRules(t *testing.T) {
+	assert := assert.New(t)
+
+	s, err := newSampler("0.1 level=info,DEBUG label=com.example.tier=web; 0.5 source=stderr", DEFAULT_SAMPLE_LEVEL_FIELD, nil)
+	assert.Nil(err)
+	assert.Len(s.rules, 2)
+	assert.Equal([]string{LEVEL_INFO, LEVEL_DEBUG}, s.rules[0].levels)
+
+	random := 0.3
+	s.random = func() float64 { return random }
+
+	keep, rate := s.Sample(testMessage("f00ffd9428dc", "stdout", `{"level":"info"}`, webTier))
+	assert.False(keep)
+	assert.Equal(0.1, rate)
+	keep, rate = s.Sample(testMessage("f00ffd9428dc", "stderr", `{"level":"info"}`, webTier))
+	assert.False(keep)
+	assert.Equal(0.1, rate)
+
+	// errors are always kept
+	keep, rate = s.Sample(testMessage("f00ffd9428dc", "stdout", `{"level":"error"}`, webTier))
+	assert.True(keep)
+	assert.Equal(1.0, rate)
+	keep, rate = s.Sample(testMessage("f00ffd9428dc", "stderr", `{"level":"error"}`, webTier))
+	assert.True(keep)
+	assert.Equal(0.5, rate)
+	keep, _ = s.Sample(testMessage("f00ffd9428dc", "stdout", "plain text", webTier))
+	assert.True(keep)
+
+	random = 0.05
+	keep, rate = s.Sample(testMessage("f00ffd9428dc", "stdout", `{"level":"debug"}`, webTier))
+	assert.True(keep)
+	assert.Equal(0.1, rate)
+
+	for _, option := range []string{"1.5", "-1", "x", "0.1 level", "0.1 level=loud", "0.1 colour=red"} {
+		_, err = newSampler(option, DEFAULT_SAMPLE_LEVEL_FIELD, nil)
+		assert.NotNil(err, option)
+	}
+}
+
+func TestSamplerKey(t *testing.T) {
+	assert := assert.New(t)
+
+	s, _ := newSampler("0.5 key=trace.id", DEFAULT_SAMPLE_LEVEL_FIELD, nil)
+	s.random = func() float64 { panic("random used") }
+
+	kept := 0
+	for i := 0; i < 1000; i++ {
+		data := fmt.Sprintf(`{"trace":{"id":"trace-%d"}}`, i)
+		keep, rate := s.Sample(testMessage("f00ffd9428dc", "stdout", data, webTier))
+		assert.Equal(0.5, rate)
+		// the same trace always gets the same decision
+		again, _ := s.Sample(testMessage("f00ffd9428dc", "stderr", data, webTier))
+		assert.Equal(keep, again)
+		if keep {
+			kept++
+		}
+	}
+	assert.InDelta(500, kept, 60)
+
+	// without key, random sampling is used
+	s.random = func() float64 { return 0.9 }
+	keep, _ := s.Sample(testMessage("f00ffd9428dc", "stdout", `{"message":"no trace"}`, webTier))
+	assert.False(keep)
+}
+
+func TestHashFraction(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, value := range []string{"", "a", "trace-1", "ffffffffffffffff"} {
+		f := hashFraction(value)
+		assert.True(f >= 0 && f < 1)
+		assert.Equal(f, hashFraction(value))
+	}
+	assert.NotEqual(hashFraction("a"), hashFraction("b"))
+}
+
+func TestCreateLogstashMessageWithSampleRate(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := testMessage("f00ffd9428dc", "stdout", "hello", webTier)
+
+	msg, _ := createLogstashMessage(m, &messageOptions{sample_rate: 0.25})
+	assert.Equal(0.25, getFloat(makeQuery(msg), "sample_rate"))
+
+	msg, _ = createLogstashMessage(m, &messageOptions{sample_rate: 0.25, use_v0: true})
+	assert.Equal(0.25, getFloat(makeQuery(msg), "@fields", "sample_rate"))
+
+	msg, _ = createLogstashMessage(m, &messageOptions{})
+	_, err := makeQuery(msg).Float("sample_rate")
+	assert.NotNil(err)
+
+}
@@ -0,0 +1,100 @@
+package redis
+
+import (
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gliderlabs/logspout/router"
+)
+
+const TAG_INVALID_UTF8 = "_invalid_utf8"
+
+// ansiEscape matches ANSI escape sequences: CSI sequences (colors, cursor
+// movement), OSC sequences (e.g. window titles) and two character escapes
+// (e.g. reset).
+var ansiEscape = regexp.MustCompile(`\x1b(?:\[[0-?]*[ -/]*[@-~]|\][^\x07\x1b]*(?:\x07|\x1b\\)|[0-Z\\-~])`)
+
+// sanitizer cleans up log lines before they are parsed.
+type sanitizer struct {
+	strip_ansi    bool
+	strip_control bool
+}
+
+func (s *sanitizer) Clean(data string) string {
+	if s.strip_ansi && strings.IndexByte(data, '\x1b') >= 0 {
+		data = ansiEscape.ReplaceAllString(data, "")
+	}
+	if s.strip_control {
+		data = stripControl(data)
+	}
+	return data
+}
+
+// Process returns a channel with copies of the messages of in, with cleaned up
+// data. Messages are copied, as they are shared with other routes.
+func (s *sanitizer) Process(in chan *router.Message) chan *router.Message {
+	out := make(chan *router.Message)
+
+	go func() {
+		defer close(out)
+		for m := range in {
+			if data := s.Clean(m.Data); data != m.Data {
+				cleaned := *m
+				cleaned.Data = data
+				m = &cleaned
+			}
+			out <- m
+		}
+	}()
+
+	return out
+}
+
+// stripControl removes C0 and C1 control characters and DEL, except for tabs
+// and newlines. Invalid UTF-8 is left as is, so it is tagged when repaired.
+func stripControl(data string) string {
+	clean := true
+	for _, r := range data {
+		if isControl(r) {
+			clean = false
+			break
+		}
+	}
+	if clean {
+		return data
+	}
+
+	stripped := make([]byte, 0, len(data))
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		if !isControl(r) {
+			stripped = append(stripped, data[i:i+size]...)
+		}
+		i += size
+	}
+	return string(stripped)
+}
+
+func isControl(r rune) bool {
+	if r == '\t' || r == '\n' {
+		return false
+	}
+	return r < 0x20 || (r >= 0x7f && r <= 0x9f)
+}
+
+// repairUTF8 replaces every invalid byte of data by U+FFFD. The second result
+// reports whether data had to be repaired.
+func repairUTF8(data string) (string, bool) {
+	if utf8.ValidString(data) {
+		return data, false
+	}
+
+	repaired := make([]rune, 0, len(data))
+	for i := 0; i < len(data); {
+		r, size := utf8.DecodeRuneInString(data[i:])
+		repaired = append(repaired, r)
+		i += size
+	}
+	return string(repaired), true
+}
@@ -0,0 +1,120 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizerStripAnsi(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &sanitizer{strip_ansi: true}
+	assert.Equal("GET / 200 4ms", s.Clean("\x1b[32mGET\x1b[0m / \x1b[1;33m200\x1b[39;49m 4ms"))
+	assert.Equal("title set", s.Clean("\x1b]0;my title\x07title set"))
+	assert.Equal("cursor", s.Clean("\x1b[2K\x1b[1Gcursor"))
+	assert.Equal("reset", s.Clean("\x1bcreset"))
+	assert.Equal("no escapes\x01", s.Clean("no escapes\x01"))
+}
+
+func TestSanitizerStripControl(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &sanitizer{strip_control: true}
+	assert.Equal("a\tb\nc", s.Clean("a\tb\nc\r"))
+	assert.Equal("bell", s.Clean("\x07bell\x00\x7f"))
+	assert.Equal("c1 é", s.Clean("c1\u0085 é"))
+	// escape characters are stripped, but not their sequences
+	assert.Equal("[32mgreen", s.Clean("\x1b[32mgreen"))
+
+	s = &sanitizer{strip_ansi: true, strip_control: true}
+	assert.Equal("green", s.Clean("\x1b[32mgreen\r"))
+}
+
+func TestSanitizerStripControlKeepsInvalidUTF8(t *testing.T) {
+	assert := assert.New(t)
+
+	s := &sanitizer{strip_control: true}
+	data := s.Clean("bad\xff byte\x07 bell")
+	assert.Equal("bad\xff byte bell", data)
+
+	// so the event is still tagged
+	msg, _ := createLogstashMessage(testMessage("a", "stdout", data, nil), &messageOptions{})
+	jq := makeQuery(msg)
+	assert.Equal("bad� byte bell", getString(jq, "message"))
+	tags, _ := jq.ArrayOfStrings("tags")
+	assert.Equal([]string{TAG_INVALID_UTF8}, tags)
+}
+
+func TestSanitizerProcess(t *testing.T) {
+	assert := assert.New(t)
+
+	original := &router.Message{Data: "\x1b[31mred\x1b[0m"}
+	in := make(chan *router.Message, 1)
+	in <- original
+	close(in)
+
+	out := (&sanitizer{strip_ansi: true}).Process(in)
+	m := <-out
+	assert.Equal("red", m.Data)
+	// messages are shared with other routes, so never modified
+	assert.Equal("\x1b[31mred\x1b[0m", original.Data)
+	_, ok := <-out
+	assert.False(ok)
+}
+
+func TestRepairUTF8(t *testing.T) {
+	assert := assert.New(t)
+
+	repaired, ok := repairUTF8("valid é")
+	assert.False(ok)
+	assert.Equal("valid é", repaired)
+
+	repaired, ok = repairUTF8("in\xffvalid \xc3")
+	assert.True(ok)
+	assert.Equal("in�valid �", repaired)
+}
+
+func TestCreateLogstashMessageWithInvalidUTF8(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "f00ffd9428dc",
+			Name: "/my_db",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:4321",
+			},
+		},
+		Source: "stderr",
+		Data:   "{\"message\":\"bad \xff byte\",\"status\":\"ok\"}",
+		Time:   time.Unix(int64(1453813310), 1000000),
+	}
+
+	msg, _ := createLogstashMessage(&m, &messageOptions{})
+	jq := makeQuery(msg)
+	assert.Equal("bad � byte", getString(jq, "message"))
+	assert.Equal("ok", getString(jq, "event", "status"))
+	tags, _ := jq.ArrayOfStrings("tags")
+	assert.Equal([]string{TAG_INVALID_UTF8}, tags)
+
+	msg, _ = createLogstashMessage(&m, &messageOptions{use_v0: true})
+	jq = makeQuery(msg)
+	assert.Equal("{\"message\":\"bad � byte\",\"status\":\"ok\"}", getString(jq, "@message"))
+	tags, _ = jq.ArrayOfStrings("@fields", "tags")
+	assert.Equal([]string{TAG_INVALID_UTF8}, tags)
+
+	m.Data = "all good"
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	_, err := makeQuery(msg).Array("tags")
+	assert.NotNil(err)
+	msg, _ = createLogstashMessage(&m, &messageOptions{use_v0: true})
+	_, err = makeQuery(msg).Array("@fields", "tags")
+	assert.NotNil(err)
+
+}
@@ -0,0 +1,87 @@
+package redis
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	COMPOSE_LABEL_PROJECT    = "com.docker.compose.project"
+	COMPOSE_LABEL_SERVICE    = "com.docker.compose.service"
+	SWARM_LABEL_SERVICE_NAME = "com.docker.swarm.service.name"
+	SWARM_LABEL_TASK_NAME    = "com.docker.swarm.task.name"
+	SWARM_LABEL_TASK_ID      = "com.docker.swarm.task.id"
+	SWARM_LABEL_NODE_ID      = "com.docker.swarm.node.id"
+)
+
+type ComposeFields struct {
+	Project string `json:"project,omitempty"`
+	Service string `json:"service,omitempty"`
+}
+
+type SwarmFields struct {
+	Service string `json:"service,omitempty"`
+	Task    string `json:"task,omitempty"`
+	Slot    int    `json:"slot,omitempty"`
+	Node    string `json:"node,omitempty"`
+}
+
+// composeFields extracts the project and service from the labels docker-compose
+// puts on containers. Returns nil for containers not started by compose.
+func composeFields(labels map[string]string) *ComposeFields {
+	if labels[COMPOSE_LABEL_PROJECT] == "" && labels[COMPOSE_LABEL_SERVICE] == "" {
+		return nil
+	}
+
+	return &ComposeFields{
+		Project: labels[COMPOSE_LABEL_PROJECT],
+		Service: labels[COMPOSE_LABEL_SERVICE],
+	}
+}
+
+// swarmFields extracts service and task metadata from the labels swarm mode
+// puts on task containers. Returns nil for containers not started by swarm.
+func swarmFields(labels map[string]string) *SwarmFields {
+	if labels[SWARM_LABEL_SERVICE_NAME] == "" && labels[SWARM_LABEL_TASK_NAME] == "" {
+		return nil
+	}
+
+	service := labels[SWARM_LABEL_SERVICE_NAME]
+	task := labels[SWARM_LABEL_TASK_NAME]
+
+	return &SwarmFields{
+		Service: service,
+		Task:    task,
+		Slot:    swarmTaskSlot(service, task),
+		Node:    labels[SWARM_LABEL_NODE_ID],
+	}
+}
+
+// swarmTaskSlot derives the slot from a task name. Tasks of replicated services
+// are named '<service>.<slot>.<task id>', those of global services
+// '<service>.<node id>.<task id>' and have no slot (0 is returned).
+func swarmTaskSlot(service string, task string) int {
+	if service == "" || !strings.HasPrefix(task, service+".") {
+		return 0
+	}
+
+	parts := strings.Split(task[len(service)+1:], ".")
+	if len(parts) != 2 {
+		return 0
+	}
+	slot, err := strconv.Atoi(parts[0])
+	if err != nil || slot < 1 {
+		return 0
+	}
+	return slot
+}
+
+// swarmContainerName strips the task id suffix from the name of a swarm task
+// container, so 'web.1.0n1hgsb6z1vy8e1ljy0qvx2xv' becomes 'web.1'.
+func swarmContainerName(name string, labels map[string]string) string {
+	task_id := labels[SWARM_LABEL_TASK_ID]
+	if task_id != "" && strings.HasSuffix(name, "."+task_id) && len(name) > len(task_id)+1 {
+		return name[:len(name)-len(task_id)-1]
+	}
+	return name
+}
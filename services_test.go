@@ -0,0 +1,136 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestComposeFields(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Nil(composeFields(nil))
+	assert.Equal(&ComposeFields{Project: "shop", Service: "web"}, composeFields(map[string]string{
+		"com.docker.compose.project": "shop",
+		"com.docker.compose.service": "web",
+	}))
+}
+
+func TestSwarmTaskSlot(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(3, swarmTaskSlot("shop_web", "shop_web.3.0n1hgsb6z1vy8e1ljy0qvx2xv"))
+	assert.Equal(12, swarmTaskSlot("a.b", "a.b.12.0n1hgsb6z1vy8e1ljy0qvx2xv"))
+	// global service: node id instead of slot
+	assert.Equal(0, swarmTaskSlot("agent", "agent.8vp3rghkxmz1kqwyqc7dbpkt2.0n1hgsb6z1vy8e1ljy0qvx2xv"))
+	assert.Equal(0, swarmTaskSlot("web", "other.1.0n1hgsb6z1vy8e1ljy0qvx2xv"))
+	assert.Equal(0, swarmTaskSlot("web", "web.1"))
+	assert.Equal(0, swarmTaskSlot("", "web.1.abc"))
+}
+
+func TestSwarmContainerName(t *testing.T) {
+	assert := assert.New(t)
+
+	labels := map[string]string{"com.docker.swarm.task.id": "0n1hgsb6z1vy8e1ljy0qvx2xv"}
+	assert.Equal("shop_web.3", swarmContainerName("shop_web.3.0n1hgsb6z1vy8e1ljy0qvx2xv", labels))
+	assert.Equal("my_app", swarmContainerName("my_app", labels))
+	assert.Equal("web.1.abc", swarmContainerName("web.1.abc", nil))
+}
+
+func TestCreateLogstashMessageWithSwarm(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/shop_web.3.0n1hgsb6z1vy8e1ljy0qvx2xv",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "nginx:1.11",
+				Labels: map[string]string{
+					"com.docker.stack.namespace":    "shop",
+					"com.docker.swarm.node.id":      "8vp3rghkxmz1kqwyqc7dbpkt2",
+					"com.docker.swarm.service.name": "shop_web",
+					"com.docker.swarm.task.id":      "0n1hgsb6z1vy8e1ljy0qvx2xv",
+					"com.docker.swarm.task.name":    "shop_web.3.0n1hgsb6z1vy8e1ljy0qvx2xv",
+				},
+			},
+		},
+		Source: "stdout",
+		Data:   "hello world",
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	msg, _ := createLogstashMessage(&m, &messageOptions{compose: true, swarm: true})
+	jq := makeQuery(msg)
+
+	assert.Equal("shop_web.3", getString(jq, "docker", "name"))
+	assert.Equal("shop_web", getString(jq, "swarm", "service"))
+	assert.Equal("shop_web.3.0n1hgsb6z1vy8e1ljy0qvx2xv", getString(jq, "swarm", "task"))
+	assert.Equal(3, getInt(jq, "swarm", "slot"))
+	assert.Equal("8vp3rghkxmz1kqwyqc7dbpkt2", getString(jq, "swarm", "node"))
+	_, err := jq.Object("compose")
+	assert.NotNil(err)
+
+	msg, _ = createLogstashMessage(&m, &messageOptions{})
+	jq = makeQuery(msg)
+
+	assert.Equal("shop_web.3.0n1hgsb6z1vy8e1ljy0qvx2xv", getString(jq, "docker", "name"))
+	_, err = jq.Object("swarm")
+	assert.NotNil(err)
+
+	// swarm labels are read before dedotting
+	msg, _ = createLogstashMessage(&m, &messageOptions{swarm: true, dedot_labels: true})
+	jq = makeQuery(msg)
+	assert.Equal("shop_web.3", getString(jq, "docker", "name"))
+	assert.Equal("shop_web", getString(jq, "swarm", "service"))
+	assert.Equal("shop_web", getString(jq, "docker", "labels", "com_docker_swarm_service_name"))
+
+	// the name is normalized in layout v0 too
+	msg, _ = createLogstashMessage(&m, &messageOptions{swarm: true, use_v0: true})
+	jq = makeQuery(msg)
+	assert.Equal("shop_web.3", getString(jq, "@fields", "docker", "name"))
+
+}
+
+func TestCreateLogstashMessageWithCompose(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/shop_web_1",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "nginx:1.11",
+				Labels: map[string]string{
+					"com.docker.compose.project": "shop",
+					"com.docker.compose.service": "web",
+				},
+			},
+		},
+		Source: "stdout",
+		Data:   "hello world",
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	msg, _ := createLogstashMessage(&m, &messageOptions{compose: true, swarm: true})
+	jq := makeQuery(msg)
+
+	assert.Equal("shop_web_1", getString(jq, "docker", "name"))
+	assert.Equal("shop", getString(jq, "compose", "project"))
+	assert.Equal("web", getString(jq, "compose", "service"))
+
+	// compose labels are read before dedotting
+	msg, _ = createLogstashMessage(&m, &messageOptions{compose: true, dedot_labels: true})
+	jq = makeQuery(msg)
+	assert.Equal("shop", getString(jq, "compose", "project"))
+	assert.Equal("web", getString(jq, "compose", "service"))
+	assert.Equal("shop", m.Container.Config.Labels["com.docker.compose.project"])
+
+}
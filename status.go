@@ -0,0 +1,116 @@
+package redis
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	STATUS_PATH            = "redis/status"
+	DEFAULT_HEALTH_TIMEOUT = 60
+)
+
+type PoolStatus struct {
+	Active int `json:"active"`
+	Idle   int `json:"idle"`
+}
+
+// RouteStatus is the state of a route, as returned by the status endpoint.
+type RouteStatus struct {
+	Route        string     `json:"route"`
+	Redis        string     `json:"redis"`
+	Healthy      bool       `json:"healthy"`
+	Started      string     `json:"started"`
+	LastPush     string     `json:"last_push,omitempty"`
+	LastError    string     `json:"last_error,omitempty"`
+	LastErrorAt  string     `json:"last_error_at,omitempty"`
+	Muted        bool       `json:"muted"`
+	MuteSeconds  float64    `json:"mute_seconds"`
+	Pool         PoolStatus `json:"pool"`
+	QueueLength  int        `json:"queue_length"`
+	EventsPushed int64      `json:"events_pushed"`
+	PushErrors   int64      `json:"push_errors"`
+}
+
+type StatusResponse struct {
+	Healthy bool          `json:"healthy"`
+	Routes  []RouteStatus `json:"routes"`
+}
+
+// Failed records an error pushing an event to Redis.
+func (m *adapterMetrics) Failed(err error, now time.Time) {
+	m.status_mu.Lock()
+	defer m.status_mu.Unlock()
+	m.last_error = err.Error()
+	m.last_error_at = now
+}
+
+// Status returns the state of the route. A route is unhealthy when its last
+// push failed, and nothing was pushed for health_timeout. Idle routes are
+// healthy.
+func (m *adapterMetrics) Status(now time.Time) RouteStatus {
+	m.status_mu.Lock()
+	last_error, last_error_at := m.last_error, m.last_error_at
+	m.status_mu.Unlock()
+
+	status := RouteStatus{
+		Route:        m.route,
+		Redis:        m.address,
+		Healthy:      true,
+		Started:      m.started.UTC().Format(time.RFC3339Nano),
+		LastError:    last_error,
+		Muted:        atomic.LoadInt64(&m.mute_since) != 0,
+		MuteSeconds:  m.MuteSeconds(now),
+		QueueLength:  m.queue(),
+		EventsPushed: atomic.LoadInt64(&m.events_out),
+		PushErrors:   atomic.LoadInt64(&m.push_errors),
+	}
+	status.Pool.Active, status.Pool.Idle = m.pool()
+
+	last_success := m.started
+	if ns := atomic.LoadInt64(&m.last_push_ns); ns != 0 {
+		last_success = time.Unix(0, ns)
+		status.LastPush = last_success.UTC().Format(time.RFC3339Nano)
+	}
+	if !last_error_at.IsZero() {
+		status.LastErrorAt = last_error_at.UTC().Format(time.RFC3339Nano)
+		if last_error_at.After(last_success) && m.health_timeout > 0 && now.Sub(last_success) > m.health_timeout {
+			status.Healthy = false
+		}
+	}
+	return status
+}
+
+// statusHandler returns the state of all routes, or of the route selected
+// with the route query parameter. The status code is 503 if a route is
+// unhealthy, and 404 for an unknown route.
+func statusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		metricsRegistry.Lock()
+		adapters := append([]*adapterMetrics(nil), metricsRegistry.adapters...)
+		metricsRegistry.Unlock()
+
+		route := r.URL.Query().Get("route")
+		now := time.Now()
+		response := StatusResponse{Healthy: true, Routes: []RouteStatus{}}
+		for _, m := range adapters {
+			if route != "" && m.route != route {
+				continue
+			}
+			status := m.Status(now)
+			response.Healthy = response.Healthy && status.Healthy
+			response.Routes = append(response.Routes, status)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case route != "" && len(response.Routes) == 0:
+			w.WriteHeader(http.StatusNotFound)
+		case !response.Healthy:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(response)
+	})
+}
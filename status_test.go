@@ -0,0 +1,88 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusHealthy(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newAdapterMetrics("r1", "redis:6379")
+	m.health_timeout = time.Minute
+	now := m.started
+
+	// idle routes are healthy
+	status := m.Status(now.Add(time.Hour))
+	assert.True(status.Healthy)
+	assert.Equal("", status.LastPush)
+	assert.Equal("", status.LastError)
+
+	// an error shortly after the start is not fatal yet
+	m.Failed(errors.New("connection refused"), now.Add(time.Second))
+	status = m.Status(now.Add(30 * time.Second))
+	assert.True(status.Healthy)
+	assert.Equal("connection refused", status.LastError)
+	assert.NotEqual("", status.LastErrorAt)
+
+	// nothing succeeded for health_timeout
+	status = m.Status(now.Add(2 * time.Minute))
+	assert.False(status.Healthy)
+
+	// a push after the error makes it healthy again
+	m.last_push_ns = now.Add(90 * time.Second).UnixNano()
+	status = m.Status(now.Add(2 * time.Minute))
+	assert.True(status.Healthy)
+	assert.NotEqual("", status.LastPush)
+}
+
+func TestStatusHealthTimeoutDisabled(t *testing.T) {
+	assert := assert.New(t)
+
+	m := newAdapterMetrics("r1", "redis:6379")
+	m.Failed(errors.New("connection refused"), m.started.Add(time.Second))
+	assert.True(m.Status(m.started.Add(time.Hour)).Healthy)
+}
+
+func TestStatusHandler(t *testing.T) {
+	assert := assert.New(t)
+
+	healthy := newAdapterMetrics("status-healthy", "redis:6379")
+	healthy.pool = func() (int, int) { return 1, 2 }
+	healthy.queue = func() int { return 3 }
+	registerMetrics(healthy)
+	defer unregisterMetrics(healthy)
+
+	unhealthy := newAdapterMetrics("status-unhealthy", "redis:6380")
+	unhealthy.health_timeout = time.Second
+	unhealthy.started = time.Now().Add(-time.Minute)
+	unhealthy.Failed(errors.New("connection refused"), time.Now())
+	registerMetrics(unhealthy)
+	defer unregisterMetrics(unhealthy)
+
+	var response StatusResponse
+	w := httptest.NewRecorder()
+	statusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/"+STATUS_PATH+"?route=status-healthy", nil))
+	assert.Equal(200, w.Code)
+	assert.Equal("application/json", w.Header().Get("Content-Type"))
+	assert.Nil(json.Unmarshal(w.Body.Bytes(), &response))
+	assert.True(response.Healthy)
+	if assert.Len(response.Routes, 1) {
+		assert.Equal("redis:6379", response.Routes[0].Redis)
+		assert.Equal(PoolStatus{Active: 1, Idle: 2}, response.Routes[0].Pool)
+		assert.Equal(3, response.Routes[0].QueueLength)
+	}
+
+	w = httptest.NewRecorder()
+	statusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/"+STATUS_PATH, nil))
+	assert.Equal(503, w.Code)
+
+	w = httptest.NewRecorder()
+	statusHandler().ServeHTTP(w, httptest.NewRequest("GET", "/"+STATUS_PATH+"?route=unknown", nil))
+	assert.Equal(404, w.Code)
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TIMESTAMP_RFC3339      = "rfc3339"
+	TIMESTAMP_EPOCH        = "epoch"
+	TIMESTAMP_EPOCH_MILLIS = "epoch_millis"
+
+	// separates timestamp formats, as Go time layouts may contain commas
+	TIMESTAMP_FORMAT_SEPARATOR = "|"
+)
+
+// timestampParser parses timestamps from embedded JSON, trying each format in
+// order. A format is one of the TIMESTAMP_* names or a Go time layout.
+type timestampParser struct {
+	formats []string
+}
+
+func newTimestampParser(formats string) *timestampParser {
+	p := &timestampParser{}
+	for _, format := range strings.Split(formats, TIMESTAMP_FORMAT_SEPARATOR) {
+		if format = strings.TrimSpace(format); format != "" {
+			p.formats = append(p.formats, format)
+		}
+	}
+	return p
+}
+
+func (p *timestampParser) Parse(value interface{}) (time.Time, error) {
+	for _, format := range p.formats {
+		var t time.Time
+		var err error
+		switch format {
+		case TIMESTAMP_RFC3339:
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			t, err = time.Parse(time.RFC3339Nano, s)
+		case TIMESTAMP_EPOCH:
+			t, err = parseEpoch(value, 1)
+		case TIMESTAMP_EPOCH_MILLIS:
+			t, err = parseEpoch(value, 1000)
+		default:
+			s, ok := value.(string)
+			if !ok {
+				continue
+			}
+			t, err = time.Parse(format, s)
+		}
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unable to parse timestamp %v", value)
+}
+
+// parseEpoch parses a number, or numeric string, as time since the unix epoch
+// in 1/units of a second.
+func parseEpoch(value interface{}, units int64) (time.Time, error) {
+	var f float64
+	switch v := value.(type) {
+	case float64:
+		f = v
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(v), 64); err != nil {
+			return time.Time{}, err
+		}
+	default:
+		return time.Time{}, fmt.Errorf("not a number: %v", value)
+	}
+
+	// refuse anything outside of year 1970 - 9999, the range RFC3339 can represent
+	if math.IsNaN(f) || f < 0 || f/float64(units) > 253402300799 {
+		return time.Time{}, fmt.Errorf("epoch out of range: %v", value)
+	}
+	whole, frac := math.Modf(f)
+	nsec_per_unit := int64(time.Second) / units
+	seconds := int64(whole) / units
+	nsec := (int64(whole)%units)*nsec_per_unit + int64(frac*float64(nsec_per_unit)+0.5)
+	return time.Unix(seconds, nsec).UTC(), nil
+}
+
+// promoteTimestamp moves a parseable timestamp from the embedded JSON fields to
+// @timestamp, keeping the time Docker received the log in docker.received_at.
+func (d *LogstashMessageV1) promoteTimestamp(field string, parser *timestampParser) {
+	value, ok := d.LogtypeFields[field]
+	if !ok {
+		return
+	}
+	t, err := parser.Parse(value)
+	if err != nil {
+		return
+	}
+	d.Fields.ReceivedAt = d.Timestamp
+	d.Timestamp = t.UTC().Format(time.RFC3339Nano)
+	delete(d.LogtypeFields, field)
+}
@@ -0,0 +1,117 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fsouza/go-dockerclient"
+	"github.com/gliderlabs/logspout/router"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTimestampParser(t *testing.T) {
+	assert := assert.New(t)
+
+	parser := newTimestampParser("rfc3339|epoch_millis|02/Jan/2006:15:04:05 -0700")
+	expected := time.Date(2016, 10, 16, 10, 0, 0, 123000000, time.UTC)
+
+	ts, err := parser.Parse("2016-10-16T10:00:00.123Z")
+	assert.Nil(err)
+	assert.True(expected.Equal(ts))
+
+	ts, err = parser.Parse("2016-10-16T12:00:00.123+02:00")
+	assert.Nil(err)
+	assert.True(expected.Equal(ts))
+
+	ts, err = parser.Parse(float64(1476612000123))
+	assert.Nil(err)
+	assert.True(expected.Equal(ts))
+
+	ts, err = parser.Parse("16/Oct/2016:12:00:00 +0200")
+	assert.Nil(err)
+	assert.True(expected.Add(-123 * time.Millisecond).Equal(ts))
+
+	_, err = parser.Parse("yesterday")
+	assert.NotNil(err)
+	_, err = parser.Parse(true)
+	assert.NotNil(err)
+	_, err = parser.Parse(nil)
+	assert.NotNil(err)
+}
+
+func TestTimestampParserLayoutsWithCommas(t *testing.T) {
+	assert := assert.New(t)
+
+	parser := newTimestampParser("epoch | Mon, 02 Jan 2006 15:04:05 MST")
+	assert.Equal([]string{"epoch", "Mon, 02 Jan 2006 15:04:05 MST"}, parser.formats)
+
+	ts, err := parser.Parse("Sun, 16 Oct 2016 10:00:00 UTC")
+	assert.Nil(err)
+	assert.True(time.Date(2016, 10, 16, 10, 0, 0, 0, time.UTC).Equal(ts))
+}
+
+func TestTimestampParserEpoch(t *testing.T) {
+	assert := assert.New(t)
+
+	parser := newTimestampParser("epoch")
+
+	ts, err := parser.Parse(float64(1476612000.5))
+	assert.Nil(err)
+	assert.Equal(time.Date(2016, 10, 16, 10, 0, 0, 500000000, time.UTC), ts)
+
+	ts, err = parser.Parse("1476612000")
+	assert.Nil(err)
+	assert.Equal(time.Date(2016, 10, 16, 10, 0, 0, 0, time.UTC), ts)
+
+	_, err = parser.Parse(float64(-1))
+	assert.NotNil(err)
+	_, err = parser.Parse(float64(1e300))
+	assert.NotNil(err)
+	_, err = parser.Parse("2016-10-16T10:00:00Z")
+	assert.NotNil(err)
+}
+
+func TestCreateLogstashMessageWithJsonTimestampAndLevel(t *testing.T) {
+
+	assert := assert.New(t)
+
+	m := router.Message{
+		Container: &docker.Container{
+			ID:   "6feffd9428dc",
+			Name: "/my_app",
+			Config: &docker.Config{
+				Hostname: "container_hostname",
+				Image:    "my.registry.host:443/path/to/image:1234",
+			},
+		},
+		Source: "stdout",
+		Data:   `{"time":"2016-01-26T14:28:10.123Z","level":"WARNING","message":"buffered"}`,
+		Time:   time.Unix(int64(1453818496), 595000000),
+	}
+
+	opts := &messageOptions{
+		timestamp_field:  "time",
+		timestamp_parser: newTimestampParser("rfc3339"),
+		level_field:      "level",
+	}
+	msg, _ := createLogstashMessage(&m, opts)
+	jq := makeQuery(msg)
+
+	assert.Equal("2016-01-26T14:28:10.123Z", getString(jq, "@timestamp"))
+	assert.Equal("2016-01-26T14:28:16.595Z", getString(jq, "docker", "received_at"))
+	assert.Equal("warn", getString(jq, "level"))
+	assert.Equal("", getString(jq, "event", "time"))
+	assert.Equal("", getString(jq, "event", "level"))
+
+	// unparseable values are left alone
+	m.Data = `{"time":"yesterday","level":"loud","message":"buffered"}`
+	msg, _ = createLogstashMessage(&m, opts)
+	jq = makeQuery(msg)
+
+	assert.Equal("2016-01-26T14:28:16.595Z", getString(jq, "@timestamp"))
+	assert.Equal("", getString(jq, "docker", "received_at"))
+	assert.Equal("", getString(jq, "level"))
+	assert.Equal("yesterday", getString(jq, "event", "time"))
+	assert.Equal("loud", getString(jq, "event", "level"))
+
+}